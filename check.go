@@ -0,0 +1,206 @@
+package sqlcode
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+// CheckProblem is a problem found by Deployable.Check
+type CheckProblem struct {
+	Pos        sqlparser.Pos
+	QuotedName string
+	Message    string
+}
+
+// CheckError lists the problems found by Deployable.Check
+type CheckError struct {
+	Problems []CheckProblem
+}
+
+func (e CheckError) Error() string {
+	var msg strings.Builder
+	msg.WriteString("sqlcode check failed:\n")
+	for _, p := range e.Problems {
+		msg.WriteString(fmt.Sprintf("\n%s:%d:%d: %s: %s", p.Pos.File, p.Pos.Line, p.Pos.Col, p.QuotedName, p.Message))
+	}
+	return msg.String()
+}
+
+// checkQuerier is what Check needs; implemented by *sql.Tx
+type checkQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Check looks for problems in the uploaded code that SQL Server does not
+// report when procedures and functions are created, because of deferred
+// name resolution; that is, references to tables or other objects that do
+// not exist, and to columns that do not exist. For each procedure and
+// function it:
+//
+//   - lists references that could not be resolved (sys.dm_sql_referenced_entities),
+//   - recompiles it (sp_refreshsqlmodule), and
+//   - for procedures, runs sp_describe_first_result_set without arguments,
+//     and compares the result to the result set declared in the docstring,
+//     if any (see sqlparser.ResultSet).
+//
+// Errors sp_describe_first_result_set gives when it can not tell the result
+// (e.g. because of temporary tables or dynamic SQL) are not reported.
+// Everything is done in a transaction that is rolled back. A CheckError is
+// returned with the positions of the problems in the source files.
+//
+// See also Options.CheckAfterUpload.
+func (d Deployable) Check(ctx context.Context, dbc DB) error {
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	return d.check(ctx, tx)
+}
+
+func (d Deployable) check(ctx context.Context, q checkQuerier) error {
+	return d.checkRoutines(ctx, q, true)
+}
+
+// checkRoutines is check if all is set; otherwise only the result sets
+// declared in docstrings are verified
+func (d Deployable) checkRoutines(ctx context.Context, q checkQuerier, all bool) error {
+	var problems []CheckProblem
+	report := func(c sqlparser.Create, pos sqlparser.Pos, message string) {
+		for _, p := range problems {
+			if p.QuotedName == c.QualifiedName() && p.Message == message {
+				return
+			}
+		}
+		problems = append(problems, CheckProblem{Pos: pos, QuotedName: c.QualifiedName(), Message: message})
+	}
+
+	for _, c := range d.CodeBase.Creates {
+		if c.CreateType != "procedure" && c.CreateType != "function" {
+			continue
+		}
+		name := quoteName(NamespaceSchemaName(namespaceOrCode(c.Namespace), d.SchemaSuffix)) + "." + c.QuotedName.Value
+		if !all {
+			if declared, _ := c.ResultSet(); declared != nil {
+				if err := d.checkResultSet(ctx, q, c, name, report); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Unresolved references to other objects; references to other
+		// databases and to temporary tables can not be checked
+		unresolved, err := queryUnresolved(ctx, q, name)
+		if err != nil {
+			if !d.reportCheckError(c, err, report) {
+				return err
+			}
+			continue
+		}
+		for _, ref := range unresolved {
+			report(c, referencePos(c, ref[1]), "reference to "+ref[0]+", which does not exist")
+		}
+
+		_, err = q.ExecContext(ctx, `
+save transaction sqlcode_check;
+begin try
+    exec sp_refreshsqlmodule @name = @name;
+end try
+begin catch
+    if xact_state() = 1 rollback transaction sqlcode_check;
+    throw;
+end catch
+`, sql.Named("name", name))
+		if err != nil {
+			if !d.reportCheckError(c, err, report) {
+				return err
+			}
+			continue
+		}
+
+		if c.CreateType == "procedure" {
+			if err := d.checkResultSet(ctx, q, c, name, report); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.SliceStable(problems, func(i, j int) bool {
+			if problems[i].Pos.File != problems[j].Pos.File {
+				return problems[i].Pos.File < problems[j].Pos.File
+			}
+			return problems[i].Pos.Line < problems[j].Pos.Line
+		})
+		return CheckError{Problems: problems}
+	}
+	return nil
+}
+
+// reportCheckError reports the items of an SQL error; false if err is not
+// an SQL error and should be returned
+func (d Deployable) reportCheckError(c sqlparser.Create, err error, report func(sqlparser.Create, sqlparser.Pos, string)) bool {
+	var sqlerr mssql.Error
+	if !errors.As(err, &sqlerr) {
+		return false
+	}
+	items := sqlerr.All
+	if len(items) == 0 {
+		items = []mssql.Error{sqlerr}
+	}
+	for _, item := range items {
+		pos := c.QuotedName.Pos
+		if source, ok := d.resolveErrorItem(item.ProcName, int(item.LineNo)); ok && source.QuotedName == c.QuotedName.Value {
+			pos = source.Pos
+		}
+		report(c, pos, item.Message)
+	}
+	return true
+}
+
+// queryUnresolved lists the references from the routine that could not be
+// resolved, as pairs of the quoted name and the unquoted name of the entity
+func queryUnresolved(ctx context.Context, q checkQuerier, name string) (result [][2]string, err error) {
+	rows, err := q.QueryContext(ctx, `
+select distinct concat(quotename(referenced_schema_name) + '.', quotename(referenced_entity_name)), referenced_entity_name
+from sys.dm_sql_referenced_entities(@name, 'OBJECT')
+where referenced_id is null
+    and is_ambiguous = 0
+    and referenced_database_name is null
+    and referenced_server_name is null
+    and referenced_entity_name not like '#%'
+    and referenced_class = 1
+`, sql.Named("name", name))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var ref [2]string
+		if err := rows.Scan(&ref[0], &ref[1]); err != nil {
+			return nil, err
+		}
+		result = append(result, ref)
+	}
+	return result, rows.Err()
+}
+
+// referencePos is the position of the first use of name in the body of c,
+// or the name of c if it is not found
+func referencePos(c sqlparser.Create, name string) sqlparser.Pos {
+	for _, token := range c.Body {
+		if strings.EqualFold(unquoteName(token.RawValue), name) {
+			return token.Start
+		}
+	}
+	return c.QuotedName.Pos
+}
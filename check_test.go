@@ -0,0 +1,91 @@
+package sqlcode
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlcodetest"
+)
+
+func checkDeployable(t *testing.T, opts Options) Deployable {
+	fs := fstest.MapFS{
+		"check.sql": &fstest.MapFile{Data: []byte(`create procedure [code].Bar as
+select Missing from dbo.Exists
+go
+create procedure [code].Foo as
+select x
+from dbo.DoesNotExist
+go
+create function [code].F() returns int as begin return 1 end
+`)},
+	}
+	d, err := Include(opts, fs)
+	require.NoError(t, err)
+	return d
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	d := checkDeployable(t, Options{})
+
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	require.NoError(t, d.EnsureUploaded(ctx, dbc))
+
+	schema := "[code@" + d.SchemaSuffix + "]"
+	fake.RespondFunc("sys.dm_sql_referenced_entities", func(args map[string]interface{}) ([]string, [][]interface{}) {
+		if args["name"] == schema+".[Foo]" {
+			return []string{"", ""}, [][]interface{}{{"[dbo].[DoesNotExist]", "DoesNotExist"}}
+		}
+		return []string{"", ""}, nil
+	})
+	// Bar is described first
+	fake.FailOnce("sp_describe_first_result_set", mssql.Error{
+		Number: 207, Message: "Invalid column name 'Missing'.",
+		ProcName: "code@" + d.SchemaSuffix + ".Bar", LineNo: 2,
+	})
+
+	err := d.Check(ctx, dbc)
+	assert.EqualError(t, err, `sqlcode check failed:
+
+check.sql:2:1: [Bar]: Invalid column name 'Missing'.
+check.sql:6:10: [Foo]: reference to [dbo].[DoesNotExist], which does not exist`)
+
+	// all routines are recompiled
+	assert.Equal(t, 3, fake.Count("sp_refreshsqlmodule"))
+	assert.Equal(t, 2, fake.Count("sp_describe_first_result_set"))
+}
+
+func TestCheckIgnoresUndescribableResults(t *testing.T) {
+	d := checkDeployable(t, Options{})
+
+	fake := sqlcodetest.New()
+	fake.FailOn("sp_describe_first_result_set", mssql.Error{Number: 11526, Message: "The metadata could not be determined because statement 'select * from #t' uses a temp table."})
+	assert.NoError(t, d.Check(context.Background(), fake.DB()))
+}
+
+func TestCheckAfterUpload(t *testing.T) {
+	ctx := context.Background()
+	d := checkDeployable(t, Options{CheckAfterUpload: true})
+
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	fake.FailOnce("sp_refreshsqlmodule", mssql.Error{
+		Number: 207, Message: "Invalid column name 'Missing'.",
+		ProcName: "code@" + d.SchemaSuffix + ".Bar", LineNo: 2,
+	})
+
+	err := d.EnsureUploaded(ctx, dbc)
+	var checkErr CheckError
+	require.ErrorAs(t, err, &checkErr)
+	require.Len(t, checkErr.Problems, 1)
+	assert.Equal(t, "[Bar]", checkErr.Problems[0].QuotedName)
+	assert.Equal(t, 2, checkErr.Problems[0].Pos.Line)
+	// the upload was rolled back
+	assert.Empty(t, fake.Schemas())
+	assert.False(t, d.IsUploadedFromCache(dbc))
+}
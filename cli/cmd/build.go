@@ -1,13 +1,17 @@
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+
 	"github.com/spf13/cobra"
-	"github.com/vippsas/sqlcode"
 )
 
 var (
+	buildSourceMap string
+
 	buildCmd = &cobra.Command{
 		Use:   "build schemasuffix",
 		Short: "Dump the SQL that will be executed to populate the [code] schema to stdout",
@@ -23,7 +27,7 @@ var (
 				return err
 			}
 
-			preprocessed, err := sqlcode.Preprocess(d.CodeBase, schemasuffix)
+			preprocessed, err := d.WithSchemaSuffix(schemasuffix).Preprocess()
 			if err != nil {
 				return err
 			}
@@ -31,11 +35,22 @@ var (
 				fmt.Println(p.Lines)
 				fmt.Println("===")
 			}
+
+			if buildSourceMap != "" {
+				buf, err := json.MarshalIndent(preprocessed.SourceMap(), "", "  ")
+				if err != nil {
+					return err
+				}
+				if err := os.WriteFile(buildSourceMap, buf, 0644); err != nil {
+					return err
+				}
+			}
 			return nil
 		},
 	}
 )
 
 func init() {
+	buildCmd.Flags().StringVar(&buildSourceMap, "sourcemap", "", "also write the source map of the batches as JSON to this file")
 	rootCmd.AddCommand(buildCmd)
 }
@@ -15,6 +15,7 @@ import (
 
 	"github.com/microsoft/go-mssqldb/msdsn"
 	"github.com/sirupsen/logrus"
+	"github.com/vippsas/sqlcode"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,6 +23,17 @@ type DatabaseConfig struct {
 	Connection       string `yaml:"connection"`
 	Dsn              msdsn.Config
 	UsePasswordLogin bool
+
+	// SandboxUser and SkipImpersonation are passed on to sqlcode.Options
+	SandboxUser       string `yaml:"sandboxuser"`
+	SkipImpersonation bool   `yaml:"skipimpersonation"`
+}
+
+// options adds the settings for uploading to this database to opts
+func (dbcfg DatabaseConfig) options(opts sqlcode.Options) sqlcode.Options {
+	opts.SandboxUser = dbcfg.SandboxUser
+	opts.SkipImpersonation = dbcfg.SkipImpersonation
+	return opts
 }
 
 func OpenSocks5Sql(dsn string) (*sql.DB, error) {
@@ -59,9 +71,31 @@ func (dbcfg DatabaseConfig) Open(ctx context.Context, logger logrus.FieldLogger)
 	return OpenSocks5Sql(dbcfg.Connection)
 }
 
+// openDatabase connects to a database configured in sqlcode.yaml
+func openDatabase(ctx context.Context, dbname string) (*sql.DB, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	dbconfig, ok := config.Databases[dbname]
+	if !ok {
+		return nil, fmt.Errorf("database %s not present in configuration file", dbname)
+	}
+	return dbconfig.Open(ctx, logrus.StandardLogger())
+}
+
 type Config struct {
 	Databases   map[string]DatabaseConfig `yaml:"databases"`
 	ServiceName string                    `yaml:"servicename"`
+
+	// Dependencies maps import names to directories (relative to sqlcode.yaml)
+	// containing SQL code that is imported; see sqlcode.Options.Imports
+	Dependencies map[string]string `yaml:"dependencies"`
+
+	// Entrypoints lists procedures/functions that are used from outside the
+	// code and Go (e.g. by jobs or reports); see `sqlcode unused`. The nodes
+	// have the positions in sqlcode.yaml, for reporting names not found.
+	Entrypoints []yaml.Node `yaml:"entrypoints"`
 }
 
 func LoadConfig() (Config, error) {
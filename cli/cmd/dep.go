@@ -3,63 +3,168 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/vippsas/sqlcode"
+	"github.com/vippsas/sqlcode/sqlparser"
 )
 
 func dep(partialParseResults bool) (d sqlcode.Deployable, err error) {
-	d, err = sqlcode.Include(
-		sqlcode.Options{
-			IncludeTags:         tags,
-			PartialParseResults: partialParseResults,
-		},
-		os.DirFS(directory),
-	)
+	return includeDirectory(sqlcode.Options{PartialParseResults: partialParseResults})
+}
+
+// includeDirectory includes the SQL code in the directory, with the tags and
+// dependencies from the command line / sqlcode.yaml added to opts
+func includeDirectory(opts sqlcode.Options) (d sqlcode.Deployable, err error) {
+	imports, err := loadDependencies()
+	if err != nil {
+		return
+	}
+	opts.IncludeTags = tags
+	opts.Imports = imports
+	d, err = sqlcode.Include(opts, os.DirFS(directory))
 	return
 }
 
+// loadDependencies includes the dependencies listed in sqlcode.yaml, if present
+func loadDependencies() (imports []sqlcode.Deployable, err error) {
+	if _, err := os.Stat(filepath.Join(directory, "sqlcode.yaml")); os.IsNotExist(err) {
+		return nil, nil
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range config.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		imp, err := sqlcode.Include(
+			sqlcode.Options{
+				Name:        name,
+				IncludeTags: tags,
+			},
+			os.DirFS(filepath.Join(directory, filepath.FromSlash(config.Dependencies[name]))),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("in dependency %s: %w", name, err)
+		}
+		imports = append(imports, imp)
+	}
+	return imports, nil
+}
+
 var (
+	depFormat string
+	depFrom   []string
+	depTo     []string
+	depFiles  []string
+
 	depCmd = &cobra.Command{
 		Use:   "dep",
 		Short: "Scan the directory trees and report which files were discovered, their ordering and their dependencies",
+		Long: `Scan the directory trees and report the dependency graph of the procedures, functions and types.
+
+--format text (the default) lists what each uses and is used by; dot, mermaid and json
+are for visualizing the graph or processing it further. --from and --to limit the graph
+to what is reachable from or to the given names, and --file to create statements in
+files matching the given patterns (globs, or directories).`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 0 {
 				_ = cmd.Help()
 				return errors.New("too many arguments")
 			}
-			d, err := dep(true)
-			if err != nil {
-				fmt.Println("Error during parsing: " + err.Error())
-				fmt.Println("Treat results below with caution.")
-				fmt.Println()
-				err = nil
-			}
-			if len(d.CodeBase.Creates) == 0 && len(d.CodeBase.Declares) == 0 {
-				fmt.Println("No SQL code found in given paths")
+			var write func(g *sqlparser.Graph, w io.Writer) error
+			switch depFormat {
+			case "text":
+				write = (*sqlparser.Graph).WriteText
+			case "dot":
+				write = (*sqlparser.Graph).WriteDOT
+			case "mermaid":
+				write = (*sqlparser.Graph).WriteMermaid
+			case "json":
+				write = (*sqlparser.Graph).WriteJSON
+			default:
+				return fmt.Errorf("unknown format %s; should be text, dot, mermaid or json", depFormat)
 			}
-			if len(d.CodeBase.Errors) > 0 {
-				fmt.Println("Errors:")
-				for _, e := range d.CodeBase.Errors {
-					fmt.Printf("%s:%d:%d: %s\n", e.Pos.File, e.Pos.Line, e.Pos.Line, e.Message)
+
+			d, err := dep(true)
+			if depFormat == "text" {
+				if err != nil {
+					fmt.Println("Error during parsing: " + err.Error())
+					fmt.Println("Treat results below with caution.")
+					fmt.Println()
 				}
-			}
-			for _, c := range d.CodeBase.Creates {
-				fmt.Println(c.QuotedName.String() + ":")
-				if len(c.DependsOn) > 0 {
-					fmt.Println("  Uses:")
-					for _, u := range c.DependsOn {
-						fmt.Println("    " + u.String())
+				if len(d.CodeBase.Creates) == 0 && len(d.CodeBase.Declares) == 0 {
+					fmt.Println("No SQL code found in given paths")
+				}
+				if len(d.CodeBase.Errors) > 0 {
+					fmt.Println("Errors:")
+					for _, e := range d.CodeBase.Errors {
+						fmt.Printf("%s:%d:%d: %s\n", e.Pos.File, e.Pos.Line, e.Pos.Col, e.Message)
 					}
+					fmt.Println()
 				}
-				fmt.Println()
+			} else if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, "Error during parsing: "+err.Error())
+			}
+
+			g, err := filterGraph(sqlparser.NewGraph(d.CodeBase.Creates))
+			if err != nil {
+				return err
 			}
-			return nil
+			return write(g, os.Stdout)
 		},
 	}
 )
 
+// filterGraph applies --from, --to and --file
+func filterGraph(g *sqlparser.Graph) (*sqlparser.Graph, error) {
+	for _, filter := range []struct {
+		names   []string
+		reverse bool
+	}{{depFrom, false}, {depTo, true}} {
+		if len(filter.names) == 0 {
+			continue
+		}
+		var start []*sqlparser.Node
+		for _, name := range filter.names {
+			n, ok := g.Node(name)
+			if !ok {
+				return nil, fmt.Errorf("%s not found", name)
+			}
+			start = append(start, n)
+		}
+		reachable := g.Reachable(start, filter.reverse)
+		g = g.Subgraph(func(n *sqlparser.Node) bool { return reachable[n.Name()] })
+	}
+	if len(depFiles) > 0 {
+		g = g.Subgraph(func(n *sqlparser.Node) bool {
+			file := string(n.QuotedName.File)
+			for _, pattern := range depFiles {
+				if ok, _ := path.Match(pattern, file); ok || strings.HasPrefix(file, strings.TrimSuffix(pattern, "/")+"/") {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	return g, nil
+}
+
 func init() {
+	depCmd.Flags().StringVar(&depFormat, "format", "text", "text, dot, mermaid or json")
+	depCmd.Flags().StringSliceVar(&depFrom, "from", nil, "only what is reachable from these procedures/functions/types (what they use)")
+	depCmd.Flags().StringSliceVar(&depTo, "to", nil, "only what reaches these procedures/functions/types (what uses them)")
+	depCmd.Flags().StringSliceVar(&depFiles, "file", nil, "only create statements in files matching these globs or in these directories")
 	rootCmd.AddCommand(depCmd)
 }
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vippsas/sqlcode/docgen"
+)
+
+var (
+	docFormat    string
+	docOutput    string
+	docTitle     string
+	docSourceURL string
+
+	docCmd = &cobra.Command{
+		Use:   "doc",
+		Short: "Generate documentation of the procedures, functions, types and constants as Markdown or HTML",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				_ = cmd.Help()
+				return errors.New("too many arguments")
+			}
+			var write func(io.Writer, docgen.Site) error
+			switch docFormat {
+			case "md", "markdown":
+				write = docgen.Markdown
+			case "html":
+				write = docgen.HTML
+			default:
+				return fmt.Errorf("unknown format %s; should be md or html", docFormat)
+			}
+
+			d, err := dep(false)
+			if err != nil {
+				return err
+			}
+			site := docgen.New(d.CodeBase, docgen.Options{Title: docTitle, SourceURL: docSourceURL})
+
+			if docOutput == "" {
+				return write(os.Stdout, site)
+			}
+			f, err := os.Create(docOutput)
+			if err != nil {
+				return err
+			}
+			if err := write(f, site); err != nil {
+				_ = f.Close()
+				return err
+			}
+			return f.Close()
+		},
+	}
+)
+
+func init() {
+	docCmd.Flags().StringVar(&docFormat, "format", "md", "md or html")
+	docCmd.Flags().StringVarP(&docOutput, "output", "o", "", "write the documentation to this file instead of stdout")
+	docCmd.Flags().StringVar(&docTitle, "title", "", "title of the documentation")
+	docCmd.Flags().StringVar(&docSourceURL, "source-url", "", "prefix of links to the source files, e.g. https://github.com/org/repo/blob/main/sql/")
+	rootCmd.AddCommand(docCmd)
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+var (
+	fmtCheck bool
+
+	fmtCmd = &cobra.Command{
+		Use:   "fmt [file.sql...]",
+		Short: "Rewrite *.sql-files in the canonical sqlcode layout",
+		Long: `Rewrite *.sql-files in the canonical sqlcode layout. If no files are given, all sqlcode
+files in the directory tree are formatted. The names of changed files are printed.
+
+With --check, no files are written; instead the command exits with an error if
+any file is not formatted (for use in CI).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filenames := args
+			if len(filenames) == 0 {
+				var err error
+				filenames, err = findSqlcodeFiles(directory)
+				if err != nil {
+					return err
+				}
+			}
+
+			var unformatted []string
+			for _, filename := range filenames {
+				buf, err := os.ReadFile(filename)
+				if err != nil {
+					return err
+				}
+				formatted, err := sqlparser.Format(sqlparser.FileRef(filename), string(buf))
+				if err != nil {
+					return err
+				}
+				if formatted == string(buf) {
+					continue
+				}
+				fmt.Println(filename)
+				unformatted = append(unformatted, filename)
+				if fmtCheck {
+					continue
+				}
+				info, err := os.Stat(filename)
+				if err != nil {
+					return err
+				}
+				if err := os.WriteFile(filename, []byte(formatted), info.Mode().Perm()); err != nil {
+					return err
+				}
+			}
+			if fmtCheck && len(unformatted) > 0 {
+				return fmt.Errorf("%d file(s) not formatted; run `sqlcode fmt`", len(unformatted))
+			}
+			return nil
+		},
+	}
+)
+
+// findSqlcodeFiles lists the same files in the tree as sqlparser.ParseFilesystems
+// would consider for parsing
+func findSqlcodeFiles(root string) (filenames []string, err error) {
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".sql") {
+			return nil
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if sqlparser.IsSqlcodeFile(buf) {
+			filenames = append(filenames, path)
+		}
+		return nil
+	})
+	return
+}
+
+func init() {
+	fmtCmd.Flags().BoolVar(&fmtCheck, "check", false, "do not write files; exit with an error if any file needs formatting")
+	rootCmd.AddCommand(fmtCmd)
+}
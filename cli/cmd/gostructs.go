@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	gostructsPackage string
+	gostructsOutput  string
+
+	gostructsCmd = &cobra.Command{
+		Use:   "gostructs",
+		Short: "Generate Go structs for the result sets declared in docstrings (`--! resultset:`)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				_ = cmd.Help()
+				return errors.New("too many arguments")
+			}
+			d, err := dep(false)
+			if err != nil {
+				return err
+			}
+			src, err := d.GoResultSetStructs(gostructsPackage)
+			if err != nil {
+				return err
+			}
+			if gostructsOutput == "" {
+				fmt.Print(string(src))
+				return nil
+			}
+			return os.WriteFile(gostructsOutput, src, 0644)
+		},
+	}
+)
+
+func init() {
+	gostructsCmd.Flags().StringVar(&gostructsPackage, "package", "db", "package name of the generated code")
+	gostructsCmd.Flags().StringVarP(&gostructsOutput, "output", "o", "", "write the generated code to this file instead of stdout")
+	rootCmd.AddCommand(gostructsCmd)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/vippsas/sqlcode"
+)
+
+var (
+	installCmd = &cobra.Command{
+		Use:   "install <dbname>",
+		Short: "Install or upgrade the sqlcode library in the SQL database configured in sqlcode.yaml",
+		Long: `Applies the sqlcode migrations (the sqlcode schema, roles and procedures) that have not
+already been applied to the database. Needs to be run as a user with db_owner or equivalent.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			if len(args) != 1 {
+				_ = cmd.Help()
+				return errors.New("Wrong number of arguments")
+			}
+			dbc, err := openDatabase(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			before, err := sqlcode.InstalledVersion(ctx, dbc)
+			if err != nil {
+				return err
+			}
+			if err := sqlcode.InstallOrUpgrade(ctx, dbc); err != nil {
+				return err
+			}
+			after, err := sqlcode.InstalledVersion(ctx, dbc)
+			if err != nil {
+				return err
+			}
+			if before == after {
+				fmt.Printf("sqlcode library is up to date (version %d)\n", after)
+			} else {
+				fmt.Printf("sqlcode library upgraded from version %d to %d\n", before, after)
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(installCmd)
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/vippsas/sqlcode"
+)
+
+var (
+	preflightCmd = &cobra.Command{
+		Use:   "preflight <dbname>",
+		Short: "Check that the SQL database configured in sqlcode.yaml is set up for uploading",
+		Long: `Checks that sqlcode is installed in the database, and that the configured user
+is allowed to upload: membership in [sqlcode-deploy-role], permission to impersonate
+the sandbox user (see sandboxuser/skipimpersonation in sqlcode.yaml), and that
+procedures, functions and types can be created. Prints what to grant for anything missing.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := logrus.StandardLogger()
+			ctx := context.Background()
+
+			if len(args) != 1 {
+				_ = cmd.Help()
+				return errors.New("Wrong number of arguments")
+			}
+			dbname := args[0]
+
+			config, err := LoadConfig()
+			if err != nil {
+				return err
+			}
+			dbconfig, ok := config.Databases[dbname]
+			if !ok {
+				return fmt.Errorf("database %s not present in configuration file", dbname)
+			}
+			dbc, err := dbconfig.Open(ctx, logger)
+			if err != nil {
+				return err
+			}
+
+			d, err := includeDirectory(dbconfig.options(sqlcode.Options{}))
+			if err != nil {
+				return err
+			}
+			if err := d.Preflight(ctx, dbc); err != nil {
+				return err
+			}
+			fmt.Println("Preflight check OK")
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(preflightCmd)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vippsas/sqlcode"
+)
+
+var (
+	promoteAlias string
+
+	promoteCmd = &cobra.Command{
+		Use:   "promote <dbname>:<schemasuffix>",
+		Short: "Point a stable alias, e.g. [code@current], to the code uploaded with a schema suffix",
+		Long: `Creates a synonym in [<namespace>@<alias>] for each procedure, function and view in
+the [<namespace>@<schemasuffix>] schemas, replacing the synonyms of the previous promotion,
+in a single transaction. The promotion is recorded, so that 'sqlcode rollback' can go back
+to the previous schema suffix.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				_ = cmd.Help()
+				return errors.New("Wrong number of arguments")
+			}
+			dbname, schemasuffix, ok := strings.Cut(args[0], ":")
+			if !ok || schemasuffix == "" {
+				_ = cmd.Help()
+				return errors.New("Illegal target, should be <dbname>:<schemasuffix>")
+			}
+			ctx := context.Background()
+			dbc, err := openDatabase(ctx, dbname)
+			if err != nil {
+				return err
+			}
+
+			if err := sqlcode.Promote(ctx, dbc, schemasuffix, promoteAlias); err != nil {
+				return err
+			}
+			fmt.Printf("[%s] now points to [%s]\n", sqlcode.SchemaName(promoteAlias), sqlcode.SchemaName(schemasuffix))
+			return nil
+		},
+	}
+
+	rollbackCmd = &cobra.Command{
+		Use:   "rollback <dbname>",
+		Short: "Point a stable alias back to the schema suffix it was promoted to before",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				_ = cmd.Help()
+				return errors.New("Wrong number of arguments")
+			}
+			ctx := context.Background()
+			dbc, err := openDatabase(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			schemasuffix, err := sqlcode.Rollback(ctx, dbc, promoteAlias)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("[%s] now points to [%s]\n", sqlcode.SchemaName(promoteAlias), sqlcode.SchemaName(schemasuffix))
+			return nil
+		},
+	}
+)
+
+func init() {
+	promoteCmd.Flags().StringVar(&promoteAlias, "as", "current", "the alias to promote to")
+	rollbackCmd.Flags().StringVar(&promoteAlias, "as", "current", "the alias to roll back")
+	rootCmd.AddCommand(promoteCmd)
+	rootCmd.AddCommand(rollbackCmd)
+}
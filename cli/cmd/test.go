@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/vippsas/sqlcode"
+	"github.com/vippsas/sqlcode/sqltest"
+)
+
+var (
+	testJUnit string
+
+	testCmd = &cobra.Command{
+		Use:   "test <dbname>",
+		Short: "Run the SQL tests against the SQL database configured in sqlcode.yaml",
+		Long: `Uploads the SQL code, including tests, to a throwaway schema and runs all procedures
+named [code].[test:...] or in files with the --sqlcode:test pragma. Each test runs in its own
+transaction which is rolled back. The schema is dropped afterwards.
+
+Results are printed in the format of 'go test -v'; use --junit to also write JUnit XML.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := logrus.StandardLogger()
+			ctx := context.Background()
+
+			if len(args) != 1 {
+				_ = cmd.Help()
+				return errors.New("Wrong number of arguments")
+			}
+			dbname := args[0]
+
+			config, err := LoadConfig()
+			if err != nil {
+				return err
+			}
+			dbconfig, ok := config.Databases[dbname]
+			if !ok {
+				return fmt.Errorf("database %s not present in configuration file", dbname)
+			}
+			dbc, err := dbconfig.Open(ctx, logger)
+			if err != nil {
+				return err
+			}
+
+			d, err := includeDirectory(dbconfig.options(sqlcode.Options{}))
+			if err != nil {
+				return err
+			}
+
+			results, err := sqltest.RunSQLTests(ctx, dbc, d)
+			if err != nil {
+				return err
+			}
+			if err := results.WriteGoTest(os.Stdout); err != nil {
+				return err
+			}
+
+			if testJUnit != "" {
+				f, err := os.Create(testJUnit)
+				if err != nil {
+					return err
+				}
+				suiteName := config.ServiceName
+				if suiteName == "" {
+					suiteName, _ = filepath.Abs(directory)
+					suiteName = filepath.Base(suiteName)
+				}
+				err = results.WriteJUnit(f, suiteName)
+				if closeErr := f.Close(); err == nil {
+					err = closeErr
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			if failed := results.Failed(); failed > 0 {
+				return fmt.Errorf("%d of %d test(s) failed", failed, len(results))
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	testCmd.Flags().StringVar(&testJUnit, "junit", "", "also write the results as JUnit XML to this file")
+	rootCmd.AddCommand(testCmd)
+}
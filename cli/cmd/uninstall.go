@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vippsas/sqlcode"
+)
+
+var (
+	uninstallDryRun       bool
+	uninstallForce        bool
+	uninstallRecentlyUsed time.Duration
+
+	uninstallCmd = &cobra.Command{
+		Use:   "uninstall <dbname>",
+		Short: "Remove the sqlcode library and all code schemas from the SQL database configured in sqlcode.yaml",
+		Long: `Drops all [code@...] schemas, the sqlcode schema, the certificates used to sign its
+procedures and the sqlcode roles and users, in a single transaction. Refuses to run while
+code schemas are locked or have been used recently, unless --force is given. Recent use is
+only known with the VIEW SERVER STATE permission (VIEW DATABASE STATE on Azure SQL Database);
+without it, all code schemas are considered in use.
+
+Use --dry-run to only print the statements that would be executed; schemas in use are then
+reported after the statements.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			if len(args) != 1 {
+				_ = cmd.Help()
+				return errors.New("Wrong number of arguments")
+			}
+			dbc, err := openDatabase(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			statements, err := sqlcode.Uninstall(ctx, dbc, sqlcode.UninstallOptions{
+				DryRun:       uninstallDryRun,
+				Force:        uninstallForce,
+				RecentlyUsed: uninstallRecentlyUsed,
+			})
+			for _, stmt := range statements {
+				fmt.Println(stmt + ";")
+			}
+			if err != nil {
+				return err
+			}
+			if !uninstallDryRun {
+				fmt.Println("sqlcode uninstalled")
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	uninstallCmd.Flags().BoolVar(&uninstallDryRun, "dry-run", false, "only print the statements that would be executed")
+	uninstallCmd.Flags().BoolVar(&uninstallForce, "force", false, "uninstall even if code schemas are in use")
+	uninstallCmd.Flags().DurationVar(&uninstallRecentlyUsed, "recently-used", 24*time.Hour, "schemas used within this duration are considered in use")
+	rootCmd.AddCommand(uninstallCmd)
+}
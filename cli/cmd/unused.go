@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/vippsas/sqlcode"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	unusedGoDir string
+	unusedCheck bool
+
+	unusedCmd = &cobra.Command{
+		Use:   "unused",
+		Short: "List procedures, functions and types that are not used from Go code or entry points",
+		Long: `List procedures, functions and types that can not be reached through their dependencies from
+  - references like [code].MyProc in Go string literals passed to Patch, in the Go module
+    containing the directory (or in --go),
+  - procedures with "entrypoint: true" in their YAML docstring,
+  - the names listed under "entrypoints" in sqlcode.yaml.
+
+References from Go or sqlcode.yaml to code that is not found are listed too. With --check,
+the command exits with an error if anything is unused or not found (for use in CI).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				_ = cmd.Help()
+				return errors.New("too many arguments")
+			}
+			d, err := dep(false)
+			if err != nil {
+				return err
+			}
+
+			goDir := unusedGoDir
+			if goDir == "" {
+				goDir, err = findGoModule(directory)
+				if err != nil {
+					return err
+				}
+			}
+			refs, err := sqlcode.ScanGoReferences(goDir)
+			if err != nil {
+				return err
+			}
+			// the names, and where each of them is referred to
+			var roots, entrypoints []string
+			where := make(map[string][]string)
+			for _, ref := range refs {
+				roots = append(roots, ref.Name)
+				where[ref.Name] = append(where[ref.Name], ref.Pos.String())
+			}
+			configFilename := path.Join(directory, "sqlcode.yaml")
+			if _, err := os.Stat(configFilename); err == nil {
+				config, err := LoadConfig()
+				if err != nil {
+					return err
+				}
+				for _, e := range config.Entrypoints {
+					if e.Kind != yaml.ScalarNode {
+						return fmt.Errorf("%s:%d:%d: entrypoints should be a list of names", configFilename, e.Line, e.Column)
+					}
+					entrypoints = append(entrypoints, e.Value)
+					where[e.Value] = append(where[e.Value], fmt.Sprintf("%s:%d:%d", configFilename, e.Line, e.Column))
+				}
+			}
+
+			unused, unknown := d.Unused(roots, entrypoints)
+			for _, c := range unused {
+				fmt.Printf("%s:%d:%d: %s (%s) is unused\n", c.QuotedName.File, c.QuotedName.Line, c.QuotedName.Col, c.QualifiedName(), c.CreateType)
+			}
+			for _, name := range unknown {
+				for _, pos := range where[name] {
+					fmt.Printf("%s: %s not found\n", pos, name)
+				}
+			}
+			if unusedCheck && (len(unused) > 0 || len(unknown) > 0) {
+				return fmt.Errorf("%d unused procedure(s)/function(s)/type(s), %d name(s) not found", len(unused), len(unknown))
+			}
+			return nil
+		},
+	}
+)
+
+// findGoModule finds the directory with the go.mod of the module dir is in
+func findGoModule(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("no go.mod found; use --go to give the directory with the Go code")
+		}
+		dir = parent
+	}
+}
+
+func init() {
+	unusedCmd.Flags().StringVar(&unusedGoDir, "go", "", "directory with the Go code using the SQL code; by default the Go module containing the directory")
+	unusedCmd.Flags().BoolVar(&unusedCheck, "check", false, "exit with an error if anything is unused")
+	rootCmd.AddCommand(unusedCmd)
+}
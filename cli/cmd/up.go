@@ -11,6 +11,9 @@ import (
 )
 
 var (
+	upWarmup bool
+	upCheck  bool
+
 	upCmd = &cobra.Command{
 		Use:   "up <dbname>:<schemasuffix>",
 		Short: "Uploads the SQL code to the SQL database configured in sqlcode.yaml",
@@ -49,23 +52,24 @@ var (
 				return err
 			}
 
+			deployable, err := includeDirectory(dbconfig.options(sqlcode.Options{WarmupAfterUpload: upWarmup, CheckAfterUpload: upCheck}))
+			if err != nil {
+				return err
+			}
+			deployable = deployable.WithSchemaSuffix(schemasuffix)
+
 			exists, err := sqlcode.Exists(ctx, dbc, schemasuffix)
 			if err != nil {
 				return err
 			}
 			if exists {
 				fmt.Println(fmt.Sprintf("Schema [%s] already exists, removing", sqlcode.SchemaName(schemasuffix)))
-				if err := sqlcode.Drop(ctx, dbc, schemasuffix); err != nil {
+				if err := deployable.Drop(ctx, dbc); err != nil {
 					return err
 				}
 			}
 
-			deployable, err := dep(false)
-			if err != nil {
-				return err
-			}
-			deployable = deployable.WithSchemaSuffix(schemasuffix)
-			err = deployable.DropAndUpload(ctx, dbc)
+			err = deployable.Upload(ctx, dbc)
 			if err != nil {
 				return err
 			}
@@ -77,5 +81,7 @@ var (
 )
 
 func init() {
+	upCmd.Flags().BoolVar(&upWarmup, "warmup", false, "call the procedures with `warmup` in their docstring after uploading")
+	upCmd.Flags().BoolVar(&upCheck, "check", false, "fail the upload if the code refers to tables, columns or other objects that do not exist")
 	rootCmd.AddCommand(upCmd)
 }
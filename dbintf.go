@@ -14,3 +14,12 @@ type DB interface {
 }
 
 var _ DB = &sql.DB{}
+
+// RowQuerier is the part of DB needed for simple queries; it is also
+// implemented by *sql.Conn and *sql.Tx
+type RowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var _ RowQuerier = &sql.Conn{}
+var _ RowQuerier = &sql.Tx{}
@@ -3,9 +3,11 @@ package sqlcode
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 )
 
-func Exists(ctx context.Context, dbc DB, schemasuffix string) (bool, error) {
+func Exists(ctx context.Context, dbc RowQuerier, schemasuffix string) (bool, error) {
 	var schemaID int
 	err := dbc.QueryRowContext(ctx, `select isnull(schema_id(@p1), 0)`, SchemaName(schemasuffix)).Scan(&schemaID)
 	if err != nil {
@@ -31,3 +33,117 @@ func Drop(ctx context.Context, dbc DB, schemasuffix string) error {
 	}
 	return err
 }
+
+// DropNamespaces drops the schemas `[<namespace>@<schemasuffix>]` for each of
+// the namespaces in a single transaction. Schemas that do not exist are
+// skipped. As code in one namespace may use types in another, and the other
+// way around, the procedures, functions and views of all the schemas are
+// dropped before sqlcode.DropCodeSchema drops the types and the schemas.
+func DropNamespaces(ctx context.Context, dbc DB, schemasuffix string, namespaces []string) error {
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var existing []string
+	for _, ns := range namespaces {
+		var schemaID int
+		err = tx.QueryRowContext(ctx, `select isnull(schema_id(@p1), 0)`, NamespaceSchemaName(ns, schemasuffix)).Scan(&schemaID)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if schemaID != 0 {
+			existing = append(existing, ns)
+		}
+	}
+	for _, ns := range existing {
+		if err := dropRoutines(ctx, tx, NamespaceSchemaName(ns, schemasuffix)); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	for _, ns := range existing {
+		_, err = tx.ExecContext(ctx, `sqlcode.DropCodeSchema`, namespaceArgs(schemasuffix, ns)...)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// dropRoutines drops the procedures, functions and views of a schema, like
+// the first part of sqlcode.DropCodeSchema
+func dropRoutines(ctx context.Context, tx *sql.Tx, schema string) error {
+	rows, err := tx.QueryContext(ctx, `
+select concat('drop ', iif(o.type in ('P', 'PC'), 'procedure', iif(o.type = 'V', 'view', 'function')), ' ', quotename(s.name), '.', quotename(o.name))
+from sys.objects o
+join sys.schemas s on s.schema_id = o.schema_id
+where s.name = @p1 and o.type in ('FN', 'IF', 'TF', 'P', 'PC', 'V')
+`, schema)
+	if err != nil {
+		return err
+	}
+	var statements []string
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		statements = append(statements, stmt)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namespaceArgs are the arguments to sqlcode.CreateCodeSchema / sqlcode.DropCodeSchema.
+// @namespace was introduced in migration 0003, so we only pass it when needed
+// to stay compatible with databases where it has not been installed.
+func namespaceArgs(schemasuffix, namespace string) []interface{} {
+	args := []interface{}{sql.Named("schemasuffix", schemasuffix)}
+	if namespace != "code" {
+		args = append(args, sql.Named("namespace", namespace))
+	}
+	return args
+}
+
+// lockInTx takes an exclusive application lock that is released when tx ends
+func lockInTx(ctx context.Context, tx *sql.Tx, resource string) error {
+	var lockRetCode int
+	err := tx.QueryRowContext(ctx, `
+declare @retcode int;
+exec @retcode = sp_getapplock @Resource = @resource, @LockMode = 'Exclusive', @LockOwner = 'Transaction', @LockTimeout = @timeoutMs;
+select @retcode;
+`,
+		sql.Named("resource", resource),
+		sql.Named("timeoutMs", DefaultLockTimeout.Milliseconds()),
+	).Scan(&lockRetCode)
+	if err != nil {
+		return err
+	}
+	if lockRetCode < 0 {
+		return fmt.Errorf("%w (%s)", errLockTimeout, resource)
+	}
+	return nil
+}
+
+// quoteString quotes s as an SQL string literal
+func quoteString(s string) string {
+	return "N'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteName quotes s as an SQL identifier, like quotename()
+func quoteName(s string) string {
+	return "[" + strings.ReplaceAll(s, "]", "]]") + "]"
+}
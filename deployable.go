@@ -19,28 +19,72 @@ type Deployable struct {
 	ParsedFiles  []string // mainly for use in error messages etc
 	CodeBase     sqlparser.Document
 
+	// Name is the name other Deployables use to refer to this one when
+	// importing it; see Options.Name
+	Name string
+	// Imports are uploaded to their own schemas, and referred to as `[<name>]`
+	// in CodeBase; see Options.Imports
+	Imports []Deployable
+
+	// see Options.SandboxUser and Options.SkipImpersonation
+	sandboxUser       string
+	skipImpersonation bool
+
+	// see Options.LockTimeout and Options.LockRetries
+	lockTimeout time.Duration
+	lockRetries int
+
+	// see Options.WarmupAfterUpload and Options.CheckAfterUpload
+	warmupAfterUpload bool
+	checkAfterUpload  bool
+
 	// cache over whether it has been uploaded to a given DB
-	// (the same physical DB can be in this map multiple times under
-	// different interfaces; that's fine; in general the same interface
-	// seems to be acquired)
-	uploaded map[DB]struct{}
+	uploaded *uploadCache
+
+	// the compiled replacements of Patch
+	patcher *derived[*schemaPatcher]
+
+	// the batches ResolveError looks up errors in
+	errors *derived[map[string][]errorSource]
 }
 
 func (d Deployable) WithSchemaSuffix(schemaSuffix string) Deployable {
 	return Deployable{
-		SchemaSuffix: schemaSuffix,
-		CodeBase:     d.CodeBase,
-		uploaded:     make(map[DB]struct{}),
+		SchemaSuffix:      schemaSuffix,
+		CodeBase:          d.CodeBase,
+		Name:              d.Name,
+		Imports:           d.Imports,
+		sandboxUser:       d.sandboxUser,
+		skipImpersonation: d.skipImpersonation,
+		lockTimeout:       d.lockTimeout,
+		lockRetries:       d.lockRetries,
+		warmupAfterUpload: d.warmupAfterUpload,
+		checkAfterUpload:  d.checkAfterUpload,
+		uploaded:          newUploadCache(),
+		patcher:           &derived[*schemaPatcher]{},
+		errors:            &derived[map[string][]errorSource]{},
 	}
 }
 
-// impersonate manages impersonating another user (presumably one with fewer privleges)
-// for an operation
-func impersonate(ctx context.Context, dbc DB, username string, f func(conn *sql.Conn) error) error {
-	if strings.Contains(username, "\"") {
-		panic("assertion failed")
+// DefaultSandboxUser is the user impersonated during upload unless
+// Options.SandboxUser is set; it is created by the sqlcode migrations
+const DefaultSandboxUser = "sqlcode-deploy-sandbox-user"
+
+// SandboxUser is the user impersonated during upload, or "" if
+// impersonation is skipped
+func (d Deployable) SandboxUser() string {
+	if d.skipImpersonation {
+		return ""
+	}
+	if d.sandboxUser == "" {
+		return DefaultSandboxUser
 	}
+	return d.sandboxUser
+}
 
+// sandboxed runs f on a connection impersonating the sandbox user,
+// or as the connected user if Options.SkipImpersonation is set
+func (d Deployable) sandboxed(ctx context.Context, dbc DB, f func(conn *sql.Conn) error) error {
 	conn, err := dbc.Conn(ctx)
 	if err != nil {
 		return err
@@ -48,6 +92,23 @@ func impersonate(ctx context.Context, dbc DB, username string, f func(conn *sql.
 	defer func() {
 		_ = conn.Close()
 	}()
+	return d.sandboxedOn(conn, f)
+}
+
+// sandboxedOn is sandboxed on a given connection
+func (d Deployable) sandboxedOn(conn *sql.Conn, f func(conn *sql.Conn) error) error {
+	if d.skipImpersonation {
+		return f(conn)
+	}
+	return impersonate(conn, d.SandboxUser(), f)
+}
+
+// impersonate manages impersonating another user (presumably one with fewer privleges)
+// for an operation on conn
+func impersonate(conn *sql.Conn, username string, f func(conn *sql.Conn) error) error {
+	if username == "" {
+		return errors.New("no user to impersonate")
+	}
 
 	// a cookie is used to be able to revert the connection back to original
 	// privileges
@@ -55,13 +116,13 @@ func impersonate(ctx context.Context, dbc DB, username string, f func(conn *sql.
 
 	// Note: we don't want to time out when messing with privileges, so
 	// use context.Background here
-	err = conn.QueryRowContext(context.Background(), fmt.Sprintf(`
+	err := conn.QueryRowContext(context.Background(), `
 		declare @cookie varbinary(8000);
-		execute as user = '%s' with cookie into @cookie;
+		execute as user = @username with cookie into @cookie;
 		select @cookie
-`, username)).Scan(&executeAsCookie)
+`, sql.Named("username", username)).Scan(&executeAsCookie)
 	if err != nil {
-		return err
+		return fmt.Errorf("while impersonating [%s] (see Deployable.Preflight): %w", username, err)
 	}
 
 	// OK we have dropped privileges, now make sure that no matter what
@@ -75,26 +136,50 @@ func impersonate(ctx context.Context, dbc DB, username string, f func(conn *sql.
 }
 
 // Upload will create and upload the schema; resulting in an error
-// if the schema already exists
+// if the schema already exists, or a LibraryVersionError if the sqlcode
+// library in the database is missing or too old
 func (d *Deployable) Upload(ctx context.Context, dbc DB) error {
+	// The code may refer to the imports, so they need to be in place first
+	if err := d.ensureImportsUploaded(ctx, dbc); err != nil {
+		return err
+	}
+
+	conn, err := dbc.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return d.upload(ctx, dbc, conn)
+}
+
+// upload is Upload on a given connection, once the imports are in place;
+// nothing else is taken from the pool of dbc, so that EnsureUploaded also
+// works with a single connection
+func (d *Deployable) upload(ctx context.Context, dbc DB, conn *sql.Conn) error {
+	if err := d.checkLibraryVersion(ctx, conn); err != nil {
+		return err
+	}
+
 	// First, impersonate a user with minimal privileges to get at least
 	// some level of sandboxing so that migration scripts can't do anything
 	// the caller didn't expect them to.
-	return impersonate(ctx, dbc, "sqlcode-deploy-sandbox-user", func(conn *sql.Conn) error {
+	err := d.sandboxedOn(conn, func(conn *sql.Conn) error {
 		tx, err := conn.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
 
-		_, err = tx.ExecContext(ctx, `sqlcode.CreateCodeSchema`,
-			sql.Named("schemasuffix", d.SchemaSuffix),
-		)
-		if err != nil {
-			_ = tx.Rollback()
-			return err
+		// All namespaces are created in the same transaction, so that
+		// they are uploaded as one unit
+		for _, ns := range d.CodeBase.Namespaces() {
+			_, err = tx.ExecContext(ctx, `sqlcode.CreateCodeSchema`, namespaceArgs(d.SchemaSuffix, ns)...)
+			if err != nil {
+				_ = tx.Rollback()
+				return err
+			}
 		}
 
-		preprocessed, err := Preprocess(d.CodeBase, d.SchemaSuffix)
+		preprocessed, err := d.Preprocess()
 		if err != nil {
 			_ = tx.Rollback()
 			return err
@@ -114,6 +199,11 @@ func (d *Deployable) Upload(ctx context.Context, dbc DB) error {
 				}
 			}
 		}
+		// The result sets declared in docstrings are always verified
+		if err := d.checkRoutines(ctx, tx, d.checkAfterUpload); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
 		err = tx.Commit()
 		if err != nil {
 			return err
@@ -124,89 +214,342 @@ func (d *Deployable) Upload(ctx context.Context, dbc DB) error {
 		return nil
 
 	})
-
+	if err != nil || !d.warmupAfterUpload {
+		return err
+	}
+	// as the connected user; the sandbox user may not have access to the
+	// tables used by the code. The code is already marked as uploaded, so
+	// an error here is only reported to this caller (see
+	// Options.WarmupAfterUpload).
+	return d.warmupAll(ctx, conn)
 }
 
 // EnsureUploaded checks that the schema with the suffix already exists,
 // and if not, creates and uploads it. This is suitable for hash-based
-// schema suffixes.  A lock will be
-// taken (globally in SQL) during the process so that multiple concurrent calls
-// from services starting at the same time line up nicely
+// schema suffixes.
+//
+// Concurrent calls (e.g. from many instances of a service starting at the same
+// time) line up using a lock (globally in SQL): The check is done holding a
+// shared lock. If the schema does not exist, an exclusive lock is taken
+// before checking again and uploading, so that only one caller uploads and
+// the others wait for it and then find the schema. See Options.LockTimeout
+// and Options.LockRetries.
+//
+// It is safe to call EnsureUploaded concurrently (e.g. from HTTP handlers);
+// concurrent calls for the same DB wait for the first one to finish and get
+// its result (or try again themselves, if the first one's ctx was canceled
+// or timed out). Only one connection of dbc is used at a time, so a pool with
+// SetMaxOpenConns(1) works as well.
 func (d *Deployable) EnsureUploaded(ctx context.Context, dbc DB) error {
 	if d.IsUploadedFromCache(dbc) {
 		return nil
 	}
+	return d.uploaded.do(ctx, dbc, func() error {
+		if d.IsUploadedFromCache(dbc) {
+			return nil
+		}
+		return d.ensureUploaded(ctx, dbc)
+	})
+}
+
+func (d *Deployable) ensureUploaded(ctx context.Context, dbc DB) error {
+	if err := d.ensureImportsUploaded(ctx, dbc); err != nil {
+		return err
+	}
+
+	// The lock is owned by the session, so the same connection must be
+	// used for taking and releasing it
+	conn, err := dbc.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
 
+	for attempt := 0; ; attempt++ {
+		err = d.ensureUploadedWithLocks(ctx, dbc, conn)
+		if errors.Is(err, errLockTimeout) && attempt < d.lockRetries {
+			continue
+		}
+		return err
+	}
+}
+
+func (d *Deployable) ensureUploadedWithLocks(ctx context.Context, dbc DB, conn *sql.Conn) error {
 	lockResourceName := "sqlcode.EnsureUploaded/" + d.SchemaSuffix
 
-	// When a lock is opened with the Transaction lock owner,
-	// that lock is released when the transaction is committed or rolled back.
+	// Fast path; the shared lock only waits for an upload in progress
+	var exists bool
+	err := d.withAppLock(ctx, conn, lockResourceName, "Shared", func() (err error) {
+		exists, err = Exists(ctx, conn, d.SchemaSuffix)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if exists {
+		d.markAsUploaded(dbc)
+		return nil
+	}
+
+	// Shared locks can not be upgraded without risking deadlocks between
+	// two callers both holding it, so the shared lock is released above and
+	// the check repeated once the exclusive lock is held
+	return d.withAppLock(ctx, conn, lockResourceName, "Exclusive", func() error {
+		return d.uploadIfNotExists(ctx, dbc, conn)
+	})
+}
+
+// uploadIfNotExists is the part of EnsureUploaded done holding the exclusive lock
+func (d *Deployable) uploadIfNotExists(ctx context.Context, dbc DB, conn *sql.Conn) error {
+	exists, err := Exists(ctx, conn, d.SchemaSuffix)
+	if err != nil {
+		return err
+	}
+	if exists {
+		d.markAsUploaded(dbc)
+		return nil
+	}
+
+	// on conn, as waiting for another connection from the pool while
+	// holding this one deadlocks if there is only one
+	uploadErr := d.upload(ctx, dbc, conn)
+	if uploadErr != nil && !d.IsUploadedFromCache(dbc) {
+		// Someone not using the lock (e.g. `sqlcode up`) may have uploaded it
+		// in the meantime
+		if exists, err := Exists(ctx, conn, d.SchemaSuffix); err == nil && exists {
+			d.markAsUploaded(dbc)
+			return nil
+		}
+	}
+	return uploadErr
+}
+
+// DefaultLockTimeout is how long EnsureUploaded waits for its lock unless
+// Options.LockTimeout is set
+const DefaultLockTimeout = 20 * time.Second
+
+var errLockTimeout = errors.New("was not able to get lock before timeout")
+
+// withAppLock runs f holding an application lock owned by the session of conn
+func (d Deployable) withAppLock(ctx context.Context, conn *sql.Conn, resource, mode string, f func() error) error {
+	timeout := d.lockTimeout
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
 	var lockRetCode int
-	err := dbc.QueryRowContext(ctx, `
+	err := conn.QueryRowContext(ctx, `
 declare @retcode int;
-exec @retcode = sp_getapplock @Resource = @resource, @LockMode = 'Shared', @LockOwner = 'Session', @LockTimeout = @timeoutMs;
+exec @retcode = sp_getapplock @Resource = @resource, @LockMode = @mode, @LockOwner = 'Session', @LockTimeout = @timeoutMs;
 select @retcode;
 `,
-		sql.Named("resource", lockResourceName),
-		sql.Named("timeoutMs", 20000),
+		sql.Named("resource", resource),
+		sql.Named("mode", mode),
+		sql.Named("timeoutMs", timeout.Milliseconds()),
 	).Scan(&lockRetCode)
 	if err != nil {
 		return err
 	}
-	if lockRetCode < 0 {
-		return errors.New("was not able to get lock before timeout")
+	switch {
+	case lockRetCode == -1 || lockRetCode == -3: // timeout, or chosen as deadlock victim
+		return fmt.Errorf("%w (%s lock on %s)", errLockTimeout, strings.ToLower(mode), resource)
+	case lockRetCode < 0:
+		return fmt.Errorf("sp_getapplock on %s failed with return code %d", resource, lockRetCode)
 	}
 
 	defer func() {
-		_, _ = dbc.ExecContext(ctx, `sp_releaseapplock`,
-			sql.Named("Resource", lockResourceName),
+		// use a context that is not cancelled, so the lock is not left
+		// behind on a connection going back to the pool
+		_, _ = conn.ExecContext(context.WithoutCancel(ctx), `sp_releaseapplock`,
+			sql.Named("Resource", resource),
 			sql.Named("LockOwner", "Session"),
 		)
 	}()
+	return f()
+}
+
+func (d *Deployable) ensureImportsUploaded(ctx context.Context, dbc DB) error {
+	for i := range d.Imports {
+		if err := d.Imports[i].EnsureUploaded(ctx, dbc); err != nil {
+			return fmt.Errorf("while uploading import [%s]: %w", d.Imports[i].Name, err)
+		}
+	}
+	return nil
+}
 
-	exists, err := Exists(ctx, dbc, d.SchemaSuffix)
+// UploadWithOverwrite will always drop the schema if it exists, before
+// uploading. This is suitable for named schema suffixes.
+func (d Deployable) DropAndUpload(ctx context.Context, dbc DB) error {
+	err := d.Drop(ctx, dbc)
 	if err != nil {
 		return err
 	}
+	return d.Upload(ctx, dbc)
+}
 
-	if exists {
-		return nil
+// Drop drops the schemas of all namespaces of the receiver that exist, in a
+// single transaction. See DropNamespaces.
+func (d Deployable) Drop(ctx context.Context, dbc DB) error {
+	err := DropNamespaces(ctx, dbc, d.SchemaSuffix, d.CodeBase.Namespaces())
+	if err == nil {
+		d.Invalidate(dbc)
 	}
+	return err
+}
 
-	return d.Upload(ctx, dbc)
+// Patch will preprocess the sql passed in so that it will call SQL code
+// deployed by the receiver Deployable
+func (d Deployable) Patch(sql string) string {
+	return d.patcher.get(d.derivedKey(), func() *schemaPatcher {
+		return newSchemaPatcher(d.schemaReplacements())
+	}).patch(sql)
 }
 
-// UploadWithOverwrite will always drop the schema if it exists, before
-// uploading. This is suitable for named schema suffixes.
-func (d Deployable) DropAndUpload(ctx context.Context, dbc DB) error {
-	exists, err := Exists(ctx, dbc, d.SchemaSuffix)
-	if err != nil {
+// Preprocess is like the Preprocess function, but also resolves references
+// to the imports of the receiver
+func (d Deployable) Preprocess() (PreprocessedFile, error) {
+	return preprocess(d.CodeBase, d.SchemaSuffix, d.schemaReplacements())
+}
+
+func (d Deployable) schemaReplacements() map[string]string {
+	result := schemaReplacements(d.CodeBase.Namespaces(), d.SchemaSuffix)
+	for _, imp := range d.Imports {
+		result["["+imp.Name+"]"] = "[" + SchemaName(imp.SchemaSuffix) + "]"
+	}
+	return result
+}
+
+// ResolveError maps an error raised by procedures/functions of the receiver
+// (or its imports) at runtime back to the source files, by returning a
+// SQLRuntimeError. Errors that did not come from the receiver are returned
+// unchanged. See also Connector, to do this for all errors from a database.
+//
+// If mssql.Error.ProcName includes the schema, only procedures in our schemas
+// are recognized; otherwise a procedure with the same name in another
+// schema can be mistaken for ours.
+func (d Deployable) ResolveError(err error) error {
+	var sqlerr mssql.Error
+	if !errors.As(err, &sqlerr) {
 		return err
 	}
 
-	if exists {
-		err = Drop(ctx, dbc, d.SchemaSuffix)
+	if len(sqlerr.All) == 0 {
+		sqlerr.All = []mssql.Error{sqlerr}
+	}
+	result := SQLRuntimeError{Wrapped: sqlerr}
+	found := false
+	for _, item := range sqlerr.All {
+		source, ok := d.resolveErrorItem(item.ProcName, int(item.LineNo))
+		found = found || ok
+		result.Items = append(result.Items, source)
+	}
+	if !found {
+		return err
+	}
+	return result
+}
+
+func (d Deployable) resolveErrorItem(procName string, lineNo int) (SQLRuntimeErrorItem, bool) {
+	if procName == "" {
+		return SQLRuntimeErrorItem{}, false
+	}
+	schema, name := splitProcName(procName)
+	for _, source := range d.errorIndex()[strings.ToLower(name)] {
+		if schema != "" && !strings.EqualFold(schema, source.schema) {
+			continue
+		}
+		return SQLRuntimeErrorItem{
+			Pos:        source.batch.InputPos(lineNo, 1),
+			Namespace:  source.batch.Namespace,
+			QuotedName: source.batch.QuotedName,
+			Docstring:  source.docstring,
+		}, true
+	}
+	return SQLRuntimeErrorItem{}, false
+}
+
+// errorSource is a batch that runtime errors may be resolved to
+type errorSource struct {
+	schema    string
+	batch     Batch
+	docstring string
+}
+
+// errorIndex maps the lower-cased unquoted names of the batches of d and
+// its imports to the batches; imports come first. It is built once, as
+// ResolveError is called for every error going through a Connector.
+func (d Deployable) errorIndex() map[string][]errorSource {
+	return d.errors.get(d.derivedKey(), func() map[string][]errorSource {
+		index := make(map[string][]errorSource)
+		for _, imp := range d.Imports {
+			for name, sources := range imp.errorIndex() {
+				index[name] = append(index[name], sources...)
+			}
+		}
+		preprocessed, err := d.Preprocess()
 		if err != nil {
-			return err
+			return index
+		}
+		for i, b := range preprocessed.Batches {
+			name := strings.ToLower(unquoteName(b.QuotedName))
+			index[name] = append(index[name], errorSource{
+				schema:    NamespaceSchemaName(namespaceOrCode(b.Namespace), d.SchemaSuffix),
+				batch:     b,
+				docstring: d.createOfBatch(i).DocstringAsString(),
+			})
 		}
+		return index
+	})
+}
+
+// createOfBatch finds the Create that batch number i of d.Preprocess() was made from
+func (d Deployable) createOfBatch(i int) sqlparser.Create {
+	for _, c := range d.CodeBase.Creates {
+		if len(c.Body) == 0 {
+			continue
+		}
+		if i == 0 {
+			return c
+		}
+		i--
 	}
+	return sqlparser.Create{}
+}
 
-	return d.Upload(ctx, dbc)
+func namespaceOrCode(namespace string) string {
+	if namespace == "" {
+		return "code"
+	}
+	return namespace
 }
 
-// Patch will preprocess the sql passed in so that it will call SQL code
-// deployed by the receiver Deployable
-func (d Deployable) Patch(sql string) string {
-	return preprocessString(d.SchemaSuffix, sql)
+// splitProcName splits `schema.name` as found in mssql.Error.ProcName;
+// the schema may be missing, and either may be quoted
+func splitProcName(procName string) (schema, name string) {
+	if strings.HasPrefix(procName, "[") {
+		if end := strings.Index(procName, "]."); end != -1 {
+			return unquoteName(procName[:end+1]), unquoteName(procName[end+2:])
+		}
+		return "", unquoteName(procName)
+	}
+	if i := strings.Index(procName, "."); i != -1 && strings.Contains(procName[:i], "@") {
+		return procName[:i], unquoteName(procName[i+1:])
+	}
+	return "", procName
 }
 
 func (d *Deployable) markAsUploaded(dbc DB) {
-	d.uploaded[dbc] = struct{}{}
+	d.uploaded.mark(dbc)
 }
 
 func (d *Deployable) IsUploadedFromCache(dbc DB) bool {
-	_, found := d.uploaded[dbc]
-	return found
+	return d.uploaded.has(dbc)
+}
+
+// Invalidate makes the next EnsureUploaded check the database again,
+// instead of trusting that the code is still uploaded to dbc; for use
+// when the schema has been dropped by someone else. (Drop does this itself.)
+func (d Deployable) Invalidate(dbc DB) {
+	d.uploaded.invalidate(dbc)
 }
 
 // TODO: StringConst. This requires parsing a SQL literal, a bit too complex
@@ -236,6 +579,61 @@ func (d Deployable) MustIntConst(s string) int {
 type Options struct {
 	IncludeTags []string
 
+	// Name makes it possible to import the result in other Deployables
+	// (see Imports), where the code is referred to as `[<name>]` instead
+	// of `[code]`.
+	Name string
+
+	// Imports are Deployables (with Name set) that the SQL code refers to as
+	// `[<name>]`. Each import is uploaded to its own hash-suffixed schema,
+	// and EnsureUploaded ensures that imports are uploaded first.
+	Imports []Deployable
+
+	// SandboxUser is the database user that is impersonated during upload, so
+	// that the uploaded SQL can do no more than that user is allowed to. The
+	// default is DefaultSandboxUser, which is created by the migrations.
+	SandboxUser string
+
+	// SkipImpersonation uploads as the connected user instead; for setups where
+	// that user is already low-privileged (e.g. only a member of [sqlcode-deploy-role]),
+	// or where impersonation is not possible (e.g. some Azure AD principals).
+	SkipImpersonation bool
+
+	// LockTimeout is how long EnsureUploaded waits for the lock that makes
+	// concurrent callers line up; the default is DefaultLockTimeout. This
+	// should be longer than an upload takes.
+	LockTimeout time.Duration
+
+	// LockRetries is how many more times EnsureUploaded waits for the lock
+	// needed to upload after LockTimeout, before returning an error
+	LockRetries int
+
+	// WarmupAfterUpload calls Warmup after uploading, so that procedures
+	// with `warmup` in their docstring are compiled at deploy time. An error
+	// from Warmup is returned from Upload/EnsureUploaded, but the code stays
+	// uploaded. It is only returned to the caller that uploaded: concurrent
+	// and later calls to EnsureUploaded (in this process or others) find the
+	// code uploaded and return nil. Call Warmup to run it again.
+	WarmupAfterUpload bool
+
+	// CheckAfterUpload runs Check before committing the upload, so that
+	// code referring to tables or columns that do not exist is not uploaded;
+	// Upload/EnsureUploaded return the CheckError.
+	CheckAfterUpload bool
+
+	// DocstringSchema is a struct (or pointer to one) describing the keys
+	// allowed in the YAML documents in docstrings (the `--!` lines), with yaml
+	// tags. If set, the docstrings are decoded into it strictly by Include, and
+	// unknown keys or values of the wrong type are reported as parse errors
+	// at the `--!` line. The keys used by sqlcode itself (e.g. `warmup`) are
+	// always allowed.
+	DocstringSchema interface{}
+
+	// ExcludeTests leaves out test code (see sqlparser.Create.IsTestProcedure),
+	// so that it is not uploaded to production. It is an error if other code
+	// depends on it.
+	ExcludeTests bool
+
 	// if this is set, parsing or ordering failed and it's up to the caller
 	// to know what one is doing..
 	PartialParseResults bool
@@ -247,17 +645,100 @@ type Options struct {
 func Include(opts Options, fsys ...fs.FS) (result Deployable, err error) {
 
 	parsedFiles, doc, err := sqlparser.ParseFilesystems(fsys, opts.IncludeTags)
+	docstringErrors, schemaErr := validateDocstrings(doc, opts.DocstringSchema)
+	if schemaErr != nil {
+		return Deployable{}, schemaErr
+	}
+	doc.Errors = append(doc.Errors, docstringErrors...)
 	if len(doc.Errors) > 0 && !opts.PartialParseResults {
 		return Deployable{}, SQLCodeParseErrors{Errors: doc.Errors}
 	}
 
+	if importErr := checkImports(doc, opts.Imports); importErr != nil {
+		return Deployable{}, importErr
+	}
+
+	if resultSetErrors := checkResultSetDeclarations(doc); len(resultSetErrors) > 0 {
+		return Deployable{}, SQLCodeParseErrors{Errors: resultSetErrors}
+	}
+
+	if opts.ExcludeTests {
+		var testErrors []sqlparser.Error
+		doc, testErrors = withoutTests(doc)
+		if len(testErrors) > 0 {
+			return Deployable{}, SQLCodeParseErrors{Errors: testErrors}
+		}
+	}
+
 	result.CodeBase = doc
 	result.ParsedFiles = parsedFiles
+	result.Name = opts.Name
+	result.Imports = opts.Imports
+	result.sandboxUser = opts.SandboxUser
+	result.skipImpersonation = opts.SkipImpersonation
+	result.lockTimeout = opts.LockTimeout
+	result.lockRetries = opts.LockRetries
+	result.warmupAfterUpload = opts.WarmupAfterUpload
+	result.checkAfterUpload = opts.CheckAfterUpload
 	result.SchemaSuffix = SchemaSuffixFromHash(result.CodeBase)
-	result.uploaded = make(map[DB]struct{})
+	if len(opts.Imports) > 0 {
+		// the uploaded code depends on which versions of the imports it
+		// refers to, so the imports must be part of the hash
+		result.SchemaSuffix = schemaSuffixWithImports(result.SchemaSuffix, opts.Imports)
+	}
+	result.uploaded = newUploadCache()
+	result.patcher = &derived[*schemaPatcher]{}
+	result.errors = &derived[map[string][]errorSource]{}
 	return
 }
 
+// withoutTests removes test code from doc; it is an error if other code depends on it
+func withoutTests(doc sqlparser.Document) (sqlparser.Document, []sqlparser.Error) {
+	tests := make(map[string]bool)
+	var creates []sqlparser.Create
+	for _, c := range doc.Creates {
+		if c.Test {
+			tests[c.QualifiedName()] = true
+		} else {
+			creates = append(creates, c)
+		}
+	}
+	var errs []sqlparser.Error
+	for _, c := range creates {
+		for _, dep := range c.DependsOn {
+			if tests[dep.Value] {
+				errs = append(errs, sqlparser.Error{
+					Pos:     dep.Pos,
+					Message: fmt.Sprintf("%s depends on test code %s", c.QualifiedName(), dep.Value),
+				})
+			}
+		}
+	}
+	doc.Creates = creates
+	return doc, errs
+}
+
+func checkImports(doc sqlparser.Document, imports []Deployable) error {
+	taken := make(map[string]bool)
+	for _, ns := range doc.Namespaces() {
+		taken[ns] = true
+	}
+	taken["sqlcode"] = true
+	for _, imp := range imports {
+		if imp.Name == "" {
+			return errors.New("imported Deployable has no Name; set Options.Name when including it")
+		}
+		if strings.ContainsAny(imp.Name, "[]@") {
+			return fmt.Errorf("illegal name of import: %s", imp.Name)
+		}
+		if taken[imp.Name] {
+			return fmt.Errorf("name of import [%s] is already in use", imp.Name)
+		}
+		taken[imp.Name] = true
+	}
+	return nil
+}
+
 func MustInclude(opts Options, fsys ...fs.FS) Deployable {
 	result, err := Include(opts, fsys...)
 	if err != nil {
@@ -282,7 +763,7 @@ func (s *SchemaObject) Suffix() string {
 // This includes all current and unused schemas.
 func (d *Deployable) ListUploaded(ctx context.Context, dbc DB) []*SchemaObject {
 	objects := []*SchemaObject{}
-	impersonate(ctx, dbc, "sqlcode-deploy-sandbox-user", func(conn *sql.Conn) error {
+	d.sandboxed(ctx, dbc, func(conn *sql.Conn) error {
 		rows, err := conn.QueryContext(ctx, `
 		select 
 			s.name
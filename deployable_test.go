@@ -1,11 +1,15 @@
 package sqlcode
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 	"testing/fstest"
 
+	mssql "github.com/microsoft/go-mssqldb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlparser"
 )
 
 func TestDeployable(t *testing.T) {
@@ -23,3 +27,124 @@ declare @EnumInt int = 1, @EnumString varchar(max) = 'hello';
 	assert.Equal(t, 1, n)
 
 }
+
+func TestImports(t *testing.T) {
+	libfs := make(fstest.MapFS)
+	libfs["lib.sql"] = &fstest.MapFile{
+		Data: []byte(`create function [code].Twice(@x int) returns int as begin return 2*@x end`),
+	}
+	lib, err := Include(Options{Name: "sharedlib"}, libfs)
+	require.NoError(t, err)
+
+	fs := make(fstest.MapFS)
+	fs["test.sql"] = &fstest.MapFile{
+		Data: []byte(`create procedure [code].Foo as select [sharedlib].Twice(1)`),
+	}
+	withoutImport, err := Include(Options{}, fs)
+	require.NoError(t, err)
+	d, err := Include(Options{Imports: []Deployable{lib}}, fs)
+	require.NoError(t, err)
+
+	// the version of the import is part of the hash
+	assert.NotEqual(t, withoutImport.SchemaSuffix, d.SchemaSuffix)
+
+	preprocessed, err := d.Preprocess()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(preprocessed.Batches))
+	assert.Equal(t,
+		"create procedure [code@"+d.SchemaSuffix+"].Foo as select [code@"+lib.SchemaSuffix+"].Twice(1)",
+		preprocessed.Batches[0].Lines)
+	assert.Equal(t,
+		"select [code@"+lib.SchemaSuffix+"].Twice(1)",
+		d.Patch("select [sharedlib].Twice(1)"))
+
+	_, err = Include(Options{Imports: []Deployable{lib, lib}}, fs)
+	assert.EqualError(t, err, "name of import [sharedlib] is already in use")
+	_, err = Include(Options{Imports: []Deployable{withoutImport}}, fs)
+	assert.Error(t, err)
+}
+
+func TestResolveError(t *testing.T) {
+	fs := make(fstest.MapFS)
+	fs["test.sql"] = &fstest.MapFile{
+		Data: []byte(`declare @EnumMulti varchar(max) = 'a
+b';
+go
+-- Fails on purpose
+create procedure [code].[Foo Bar] as begin
+    select @EnumMulti
+    ;throw 50000, 'oops', 1
+end
+`),
+	}
+	d, err := Include(Options{}, fs)
+	require.NoError(t, err)
+
+	item := mssql.Error{Number: 50000, Message: "oops", ProcName: "Foo Bar", LineNo: 5}
+	other := mssql.Error{Number: 50000, Message: "other", ProcName: "Other", LineNo: 2}
+	item.All = []mssql.Error{other, item}
+
+	resolved := d.ResolveError(fmt.Errorf("wrapped: %w", item))
+	var runtimeErr SQLRuntimeError
+	require.True(t, errors.As(resolved, &runtimeErr))
+	assert.Equal(t, []SQLRuntimeErrorItem{{}, {
+		Pos:        sqlparser.Pos{File: "test.sql", Line: 7, Col: 1},
+		QuotedName: "[Foo Bar]",
+		Docstring:  "-- Fails on purpose",
+	}}, runtimeErr.Items)
+	assert.Equal(t, "(Other:2): other\ntest.sql:7:1 ([Foo Bar]): oops", resolved.Error())
+
+	// ProcName including the schema is only recognized for our own schema
+	item.All = nil
+	item.ProcName = "code@" + d.SchemaSuffix + ".Foo Bar"
+	assert.IsType(t, SQLRuntimeError{}, d.ResolveError(item))
+	item.ProcName = "[code@" + d.SchemaSuffix + "].[Foo Bar]"
+	assert.IsType(t, SQLRuntimeError{}, d.ResolveError(item))
+	item.ProcName = "[code@other].[Foo Bar]"
+	assert.Equal(t, item, d.ResolveError(item))
+
+	// errors not from our procedures are passed through
+	assert.Equal(t, other, d.ResolveError(other))
+	plain := errors.New("plain")
+	assert.Equal(t, plain, d.ResolveError(plain))
+
+	// the lookup is built once and shared by copies, until the suffix changes
+	c := d
+	assert.True(t, d.errors.ok)
+	c.SchemaSuffix = "other"
+	item.ProcName = "[code@other].[Foo Bar]"
+	assert.IsType(t, SQLRuntimeError{}, c.ResolveError(item))
+	assert.Equal(t, c.derivedKey(), d.errors.key)
+}
+
+func TestExcludeTests(t *testing.T) {
+	fs := fstest.MapFS{
+		"code.sql": &fstest.MapFile{Data: []byte(`create function [code].Twice(@x int) returns int as begin return 2*@x end`)},
+		"code_test.sql": &fstest.MapFile{Data: []byte(`--sqlcode:test
+create procedure [code].TwiceWorks as begin
+    declare @x int = [code].Twice(2)
+    exec sqlcode.AssertEquals 4, @x
+end
+`)},
+	}
+	d, err := Include(Options{ExcludeTests: true}, fs)
+	require.NoError(t, err)
+	require.Len(t, d.CodeBase.Creates, 1)
+
+	withTests, err := Include(Options{}, fs)
+	require.NoError(t, err)
+	require.Len(t, withTests.CodeBase.Creates, 2)
+	assert.True(t, withTests.CodeBase.Creates[1].IsTestProcedure())
+	assert.NotEqual(t, d.SchemaSuffix, withTests.SchemaSuffix)
+
+	// Code can not depend on test code, since it is not uploaded
+	fs["code.sql"] = &fstest.MapFile{Data: []byte(`create procedure [code].Foo as exec [code].[test:Foo]
+go
+create procedure [code].[test:Foo] as select 1
+`)}
+	delete(fs, "code_test.sql")
+	_, err = Include(Options{ExcludeTests: true}, fs)
+	assert.EqualError(t, err, "sqlcode syntax error:\n\ncode.sql:1:44: [Foo] depends on test code [test:Foo]\n")
+	_, err = Include(Options{}, fs)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,39 @@
+package sqlcode
+
+import (
+	"strings"
+	"sync"
+)
+
+// derived caches something computed from a Deployable, such as the compiled
+// replacements of Patch. It is shared by copies of the Deployable, and
+// rebuilt if the schema suffix or imports have been changed since (see
+// derivedKey). Safe for concurrent use; a nil *derived caches nothing.
+type derived[T any] struct {
+	mu    sync.Mutex
+	key   string
+	value T
+	ok    bool
+}
+
+func (c *derived[T]) get(key string, build func() T) T {
+	if c == nil {
+		return build()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.ok || c.key != key {
+		c.key, c.value, c.ok = key, build(), true
+	}
+	return c.value
+}
+
+// derivedKey identifies what the derived values depend on
+func (d Deployable) derivedKey() string {
+	var key strings.Builder
+	key.WriteString(d.SchemaSuffix)
+	for _, imp := range d.Imports {
+		key.WriteString("\x00" + imp.Name + "=" + imp.SchemaSuffix)
+	}
+	return key.String()
+}
@@ -0,0 +1,317 @@
+// Package docgen generates documentation of SQL code from the parsed
+// source, in the style of godoc: an entry per procedure, function and type
+// with its docstring, signature, YAML metadata, where it is defined, what it
+// uses and what uses it, and a table of the constants.
+package docgen
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+// Options configures the generated documentation
+type Options struct {
+	Title string
+	// SourceURL is prepended to file names to link to the source, e.g.
+	// `https://github.com/org/repo/blob/main/sql/`; `#L<line>` is appended.
+	// By default the file names are linked as relative paths.
+	SourceURL string
+}
+
+// Entry is the documentation of a procedure, function or type
+type Entry struct {
+	Kind      string // "procedure", "function" or "type"
+	Name      string // as sqlparser.Create.QualifiedName
+	Anchor    string
+	Doc       string // the docstring, without comment markers and YAML metadata
+	Signature string // the parameters, e.g. `@orderID bigint, @comment nvarchar(100) = ...`
+	Returns   string // what a function returns, as sqlparser.Create.Returns
+	Metadata  string // the YAML document in the docstring
+	File      string
+	Line      int
+	SourceURL string
+	Uses      []Link
+	UsedBy    []Link
+}
+
+// Link refers to another entry; Anchor is empty if it is not documented
+// (e.g. it is in an imported deployable)
+type Link struct {
+	Name   string
+	Anchor string
+}
+
+// Constant is a global constant declared in the code
+type Constant struct {
+	Name  string
+	Type  string
+	Value string
+	File  string
+	Line  int
+}
+
+// Site is what the documentation is generated from; see New
+type Site struct {
+	Title     string
+	Entries   []Entry
+	Constants []Constant
+}
+
+// New collects the documentation of the code base; entries are sorted by
+// kind and name
+func New(doc sqlparser.Document, opts Options) Site {
+	site := Site{Title: opts.Title}
+	if site.Title == "" {
+		site.Title = "SQL code"
+	}
+
+	g := sqlparser.NewGraph(doc.Creates)
+	anchors := anchorsOf(g.Nodes)
+	link := func(n *sqlparser.Node) Link {
+		return Link{Name: n.Name(), Anchor: anchors[n.Name()]}
+	}
+
+	for _, n := range g.Nodes {
+		c := n.Create
+		entry := Entry{
+			Kind:      c.CreateType,
+			Name:      c.QualifiedName(),
+			Anchor:    anchors[n.Name()],
+			Doc:       docOf(c),
+			Signature: signatureOf(c),
+			Returns:   c.Returns(),
+			File:      string(c.QuotedName.File),
+			Line:      c.QuotedName.Line,
+			SourceURL: fmt.Sprintf("%s%s#L%d", opts.SourceURL, c.QuotedName.File, c.QuotedName.Line),
+		}
+		entry.Metadata, _ = c.DocstringYamldoc()
+		for _, used := range n.Uses {
+			entry.Uses = append(entry.Uses, link(used))
+		}
+		for _, extern := range n.Extern {
+			entry.Uses = append(entry.Uses, Link{Name: extern})
+		}
+		for _, user := range n.UsedBy {
+			entry.UsedBy = append(entry.UsedBy, link(user))
+		}
+		entry.Uses = sortedLinks(entry.Uses)
+		entry.UsedBy = sortedLinks(entry.UsedBy)
+		site.Entries = append(site.Entries, entry)
+	}
+	sort.SliceStable(site.Entries, func(i, j int) bool {
+		a, b := site.Entries[i], site.Entries[j]
+		return entryLess(a.Kind, a.Name, b.Kind, b.Name)
+	})
+
+	for _, d := range doc.Declares {
+		site.Constants = append(site.Constants, Constant{
+			Name:  d.VariableName,
+			Type:  typeString(d.Datatype),
+			Value: d.Literal.RawValue,
+			File:  string(d.Start.File),
+			Line:  d.Start.Line,
+		})
+	}
+	sort.SliceStable(site.Constants, func(i, j int) bool {
+		return strings.ToLower(site.Constants[i].Name) < strings.ToLower(site.Constants[j].Name)
+	})
+	return site
+}
+
+func sortedLinks(links []Link) []Link {
+	sort.SliceStable(links, func(i, j int) bool { return links[i].Name < links[j].Name })
+	return links
+}
+
+var kindOrder = map[string]int{"procedure": 0, "function": 1, "type": 2}
+
+// entryLess is the order of the entries: by kind and name
+func entryLess(kindA, nameA, kindB, nameB string) bool {
+	if kindA != kindB {
+		return kindOrder[kindA] < kindOrder[kindB]
+	}
+	if !strings.EqualFold(nameA, nameB) {
+		return strings.ToLower(nameA) < strings.ToLower(nameB)
+	}
+	return nameA < nameB
+}
+
+// anchorsOf gives each node a unique anchor, by name. Names that only
+// differ in punctuation (e.g. `[GET:/orders]` and `[get_orders]`) get the
+// same anchorOf; the later ones in the order of the entries get a suffix.
+func anchorsOf(nodes []*sqlparser.Node) map[string]string {
+	sorted := append([]*sqlparser.Node(nil), nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return entryLess(sorted[i].CreateType, sorted[i].Name(), sorted[j].CreateType, sorted[j].Name())
+	})
+	result := make(map[string]string, len(nodes))
+	taken := make(map[string]bool, len(nodes))
+	for _, n := range sorted {
+		base := anchorOf(n.CreateType, n.Name())
+		anchor := base
+		for i := 2; taken[anchor]; i++ {
+			anchor = fmt.Sprintf("%s-%d", base, i)
+		}
+		taken[anchor] = true
+		result[n.Name()] = anchor
+	}
+	return result
+}
+
+func anchorOf(kind, name string) string {
+	var anchor strings.Builder
+	anchor.WriteString(kind + "-")
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			anchor.WriteRune(r)
+		case anchor.Len() > 0 && !strings.HasSuffix(anchor.String(), "-"):
+			anchor.WriteRune('-')
+		}
+	}
+	return strings.TrimSuffix(anchor.String(), "-")
+}
+
+// docOf is the docstring without comment markers and the YAML document
+func docOf(c sqlparser.Create) string {
+	var lines []string
+	for _, line := range c.Docstring {
+		if strings.HasPrefix(line.Value, "--!") {
+			continue
+		}
+		text := strings.TrimPrefix(line.Value, "--")
+		text = strings.TrimPrefix(text, " ")
+		lines = append(lines, text)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func typeString(t sqlparser.Type) string {
+	if len(t.Args) == 0 {
+		return t.BaseType
+	}
+	return fmt.Sprintf("%s(%s)", t.BaseType, strings.Join(t.Args, ", "))
+}
+
+func signatureOf(c sqlparser.Create) string {
+	var params []string
+	for _, p := range c.Parameters() {
+		param := p.Name + " " + typeString(p.Type)
+		if p.HasDefault {
+			param += " = ..."
+		}
+		if p.Output {
+			param += " output"
+		}
+		if p.ReadOnly {
+			param += " readonly"
+		}
+		params = append(params, param)
+	}
+	return strings.Join(params, ", ")
+}
+
+// Markdown writes the documentation as a single Markdown document
+func Markdown(w io.Writer, site Site) error {
+	return markdownTemplate.Execute(w, site)
+}
+
+// HTML writes the documentation as a single HTML page
+func HTML(w io.Writer, site Site) error {
+	return htmlTemplate.Execute(w, site)
+}
+
+var markdownTemplate = texttemplate.Must(texttemplate.New("md").Parse(`# {{.Title}}
+{{range .Entries}}
+- [{{.Kind}} {{.Name}}](#{{.Anchor}})
+{{- end}}
+{{range .Entries}}
+<a id="{{.Anchor}}"></a>
+## {{.Kind}} {{.Name}}
+
+` + "```sql" + `
+{{.Kind}} {{.Name}}{{if or .Signature .Returns}} ({{.Signature}}){{end}}{{if .Returns}} returns {{.Returns}}{{end}}
+` + "```" + `
+{{if .Doc}}
+{{.Doc}}
+{{end}}
+{{- if .Metadata}}
+` + "```yaml" + `
+{{.Metadata}}
+` + "```" + `
+{{end}}
+Defined in [{{.File}}:{{.Line}}]({{.SourceURL}})
+{{- if .Uses}}
+
+Uses:{{range .Uses}} {{if .Anchor}}[{{.Name}}](#{{.Anchor}}){{else}}{{.Name}}{{end}}{{end}}
+{{- end}}
+{{- if .UsedBy}}
+
+Used by:{{range .UsedBy}} [{{.Name}}](#{{.Anchor}}){{end}}
+{{- end}}
+{{end}}
+{{- if .Constants}}
+## Constants
+
+| Name | Type | Value | Defined in |
+|------|------|-------|------------|
+{{- range .Constants}}
+| {{.Name}} | {{.Type}} | ` + "`{{.Value}}`" + ` | {{.File}}:{{.Line}} |
+{{- end}}
+{{end}}`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; }
+pre { background: #f4f4f4; padding: 0.5em; }
+.doc { white-space: pre-line; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<ul>
+{{- range .Entries}}
+<li><a href="#{{.Anchor}}">{{.Kind}} {{.Name}}</a></li>
+{{- end}}
+</ul>
+{{range .Entries}}
+<h2 id="{{.Anchor}}">{{.Kind}} {{.Name}}</h2>
+<pre>{{.Kind}} {{.Name}}{{if or .Signature .Returns}} ({{.Signature}}){{end}}{{if .Returns}} returns {{.Returns}}{{end}}</pre>
+{{- if .Doc}}
+<p class="doc">{{.Doc}}</p>
+{{- end}}
+{{- if .Metadata}}
+<pre>{{.Metadata}}</pre>
+{{- end}}
+<p>Defined in <a href="{{.SourceURL}}">{{.File}}:{{.Line}}</a></p>
+{{- if .Uses}}
+<p>Uses:{{range .Uses}} {{if .Anchor}}<a href="#{{.Anchor}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}{{end}}</p>
+{{- end}}
+{{- if .UsedBy}}
+<p>Used by:{{range .UsedBy}} <a href="#{{.Anchor}}">{{.Name}}</a>{{end}}</p>
+{{- end}}
+{{end}}
+{{- if .Constants}}
+<h2 id="constants">Constants</h2>
+<table>
+<tr><th>Name</th><th>Type</th><th>Value</th><th>Defined in</th></tr>
+{{- range .Constants}}
+<tr><td>{{.Name}}</td><td>{{.Type}}</td><td><code>{{.Value}}</code></td><td>{{.File}}:{{.Line}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
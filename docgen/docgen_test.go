@@ -0,0 +1,95 @@
+package docgen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+func testDocument(t *testing.T) sqlparser.Document {
+	doc := sqlparser.ParseString("orders.sql", `declare @EnumOrderNew int = 1;
+go
+-- Lists the orders of a customer.
+--
+--! timeoutMs: 400
+create procedure [code].ListOrders (@customerID bigint, @limit int = 100) as
+select [code].FormatID(OrderID) from dbo.Orders
+go
+create function [code].FormatID (@id bigint) returns varchar(20) as begin return cast(@id as varchar(20)) end
+`)
+	require.Empty(t, doc.Errors)
+	return doc
+}
+
+func TestMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Markdown(&buf, New(testDocument(t), Options{SourceURL: "https://example.com/sql/"})))
+	assert.Equal(t, "# SQL code\n"+`
+- [procedure [ListOrders]](#procedure-listorders)
+- [function [FormatID]](#function-formatid)
+
+<a id="procedure-listorders"></a>
+## procedure [ListOrders]
+
+`+"```sql"+`
+procedure [ListOrders] (@customerID bigint, @limit int = ...)
+`+"```"+`
+
+Lists the orders of a customer.
+
+`+"```yaml"+`
+timeoutMs: 400
+`+"```"+`
+
+Defined in [orders.sql:6](https://example.com/sql/orders.sql#L6)
+
+Uses: [[FormatID]](#function-formatid)
+
+<a id="function-formatid"></a>
+## function [FormatID]
+
+`+"```sql"+`
+function [FormatID] (@id bigint) returns varchar(20)
+`+"```"+`
+
+Defined in [orders.sql:9](https://example.com/sql/orders.sql#L9)
+
+Used by: [[ListOrders]](#procedure-listorders)
+
+## Constants
+
+| Name | Type | Value | Defined in |
+|------|------|-------|------------|
+| @EnumOrderNew | int | `+"`1`"+` | orders.sql:1 |
+`, buf.String())
+}
+
+func TestHTML(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, HTML(&buf, New(testDocument(t), Options{Title: "Orders <API>"})))
+	html := buf.String()
+	assert.True(t, strings.Contains(html, "<title>Orders &lt;API&gt;</title>"))
+	assert.True(t, strings.Contains(html, `<h2 id="procedure-listorders">procedure [ListOrders]</h2>`))
+	assert.True(t, strings.Contains(html, `<p>Used by: <a href="#procedure-listorders">[ListOrders]</a></p>`))
+	assert.True(t, strings.Contains(html, `<a href="orders.sql#L9">orders.sql:9</a>`))
+	assert.True(t, strings.Contains(html, `<pre>function [FormatID] (@id bigint) returns varchar(20)</pre>`))
+}
+
+func TestAnchorsAreUnique(t *testing.T) {
+	doc := sqlparser.ParseString("orders.sql", `create procedure [code].[get_orders] as exec [code].[GET:/orders]
+go
+create procedure [code].[GET:/orders] as select 1
+`)
+	require.Empty(t, doc.Errors)
+	site := New(doc, Options{})
+	require.Len(t, site.Entries, 2)
+	assert.Equal(t, "[GET:/orders]", site.Entries[0].Name)
+	assert.Equal(t, "procedure-get-orders", site.Entries[0].Anchor)
+	assert.Equal(t, "[get_orders]", site.Entries[1].Name)
+	assert.Equal(t, "procedure-get-orders-2", site.Entries[1].Anchor)
+	assert.Equal(t, []Link{{Name: "[GET:/orders]", Anchor: "procedure-get-orders"}}, site.Entries[1].Uses)
+}
@@ -0,0 +1,105 @@
+package sqlcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vippsas/sqlcode/sqlparser"
+	"gopkg.in/yaml.v3"
+)
+
+// builtinDocstringKeys are the keys in YAML docstrings used by sqlcode
+// itself; they are allowed in addition to those of Options.DocstringSchema
+var builtinDocstringKeys = []string{"warmup", "resultset", "http", "timeoutMs", "entrypoint"}
+
+var yamlLineRegexp = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// validateDocstrings decodes the YAML docstring of every create statement
+// strictly into schema (see Options.DocstringSchema), returning errors at
+// the positions of the offending `--!` lines
+func validateDocstrings(doc sqlparser.Document, schema interface{}) (result []sqlparser.Error, err error) {
+	if schema == nil {
+		return nil, nil
+	}
+	wrapperType, err := docstringWrapperType(reflect.TypeOf(schema))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range doc.Creates {
+		yamldoc, err := c.DocstringYamldoc()
+		var parseErr sqlparser.Error
+		if errors.As(err, &parseErr) {
+			result = append(result, parseErr)
+			continue
+		}
+		if strings.TrimSpace(yamldoc) == "" {
+			continue
+		}
+		decoder := yaml.NewDecoder(bytes.NewBufferString(yamldoc))
+		decoder.KnownFields(true)
+		err = decoder.Decode(reflect.New(wrapperType).Interface())
+		if err == nil {
+			continue
+		}
+
+		var messages []string
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			messages = typeErr.Errors
+		} else {
+			messages = []string{err.Error()}
+		}
+		for _, msg := range messages {
+			pos := c.QuotedName.Pos
+			if m := yamlLineRegexp.FindStringSubmatch(msg); m != nil {
+				line, _ := strconv.Atoi(m[1])
+				pos = c.DocstringYamlPos(line)
+				msg = m[2]
+			}
+			if i := strings.Index(msg, " not found in type "); i >= 0 && strings.HasPrefix(msg, "field ") {
+				msg = "unknown key `" + strings.TrimPrefix(msg[:i], "field ") + "`"
+			}
+			result = append(result, sqlparser.Error{Pos: pos, Message: "docstring: " + msg})
+		}
+	}
+	return result, nil
+}
+
+// docstringWrapperType is a struct type with the fields of the schema inline,
+// and the built-in keys the schema does not declare itself
+func docstringWrapperType(schemaType reflect.Type) (reflect.Type, error) {
+	if schemaType.Kind() == reflect.Ptr {
+		schemaType = schemaType.Elem()
+	}
+	if schemaType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Options.DocstringSchema should be a struct, not %s", schemaType)
+	}
+
+	declared := make(map[string]bool)
+	for i := 0; i < schemaType.NumField(); i++ {
+		field := schemaType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		declared[name] = true
+	}
+
+	fields := []reflect.StructField{{Name: "Schema", Type: schemaType, Tag: `yaml:",inline"`}}
+	for i, key := range builtinDocstringKeys {
+		if !declared[key] {
+			fields = append(fields, reflect.StructField{
+				Name: fmt.Sprintf("Builtin%d", i),
+				Type: reflect.TypeOf(yaml.Node{}),
+				Tag:  reflect.StructTag(fmt.Sprintf(`yaml:"%s"`, key)),
+			})
+		}
+	}
+	return reflect.StructOf(fields), nil
+}
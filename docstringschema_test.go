@@ -0,0 +1,56 @@
+package sqlcode
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocstringSchema(t *testing.T) {
+	type schema struct {
+		TimeoutMs int      `yaml:"timeoutMs"`
+		Owners    []string `yaml:"owners"`
+	}
+	fs := fstest.MapFS{
+		"a.sql": &fstest.MapFile{Data: []byte(`-- A procedure
+--! timeoutMs: 100
+--! owners: [team-a]
+--! warmup: true
+create procedure [code].Ok as select 1
+go
+--! owners: [team-a]
+--! timeoutMS: 100
+create procedure [code].Typo as select 1
+go
+--! timeoutMs: soon
+create procedure [code].WrongType as select 1
+go
+create procedure [code].NoDocstring as select 1
+`)},
+	}
+
+	_, err := Include(Options{}, fs)
+	require.NoError(t, err)
+
+	_, err = Include(Options{DocstringSchema: schema{}}, fs)
+	var parseErrs SQLCodeParseErrors
+	require.ErrorAs(t, err, &parseErrs)
+	var messages []string
+	for _, e := range parseErrs.Errors {
+		messages = append(messages, e.Error())
+	}
+	assert.Equal(t, []string{
+		"a.sql:8:1 docstring: unknown key `timeoutMS`",
+		"a.sql:11:1 docstring: cannot unmarshal !!str `soon` into int",
+	}, messages)
+
+	// with PartialParseResults the errors end up in the document
+	d, err := Include(Options{DocstringSchema: &schema{}, PartialParseResults: true}, fs)
+	require.NoError(t, err)
+	assert.Len(t, d.CodeBase.Errors, 2)
+
+	_, err = Include(Options{DocstringSchema: "nope"}, fs)
+	assert.EqualError(t, err, "Options.DocstringSchema should be a struct, not string")
+}
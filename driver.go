@@ -0,0 +1,314 @@
+package sqlcode
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"reflect"
+)
+
+// Connector wraps a driver.Connector so that all errors returned from the
+// database pass through ResolveError; errors raised by procedures/functions
+// of the receiver then point to the source files. Usage:
+//
+//	connector, err := mssql.NewConnector(dsn)
+//	...
+//	dbi := sql.OpenDB(SQL.Connector(connector))
+//
+// Errors raised by code not uploaded by the receiver are passed through unchanged.
+func (d Deployable) Connector(connector driver.Connector) driver.Connector {
+	return resolvingConnector{Connector: connector, resolve: d.ResolveError}
+}
+
+type resolvingConnector struct {
+	driver.Connector
+	resolve func(error) error
+}
+
+func (c resolvingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resolvingConn{conn: conn, resolve: c.resolve}, nil
+}
+
+// resolvingConn wraps a driver.Conn. The optional interfaces are always
+// implemented, falling back to what database/sql would do if the wrapped
+// connection does not implement them.
+type resolvingConn struct {
+	conn    driver.Conn
+	resolve func(error) error
+}
+
+var (
+	_ driver.ConnBeginTx            = resolvingConn{}
+	_ driver.ConnPrepareContext     = resolvingConn{}
+	_ driver.ExecerContext          = resolvingConn{}
+	_ driver.QueryerContext         = resolvingConn{}
+	_ driver.Pinger                 = resolvingConn{}
+	_ driver.SessionResetter        = resolvingConn{}
+	_ driver.Validator              = resolvingConn{}
+	_ driver.NamedValueChecker      = resolvingConn{}
+	_ driver.StmtExecContext        = resolvingStmt{}
+	_ driver.StmtQueryContext       = resolvingStmt{}
+	_ driver.RowsNextResultSet      = resolvingRows{}
+	_ driver.RowsColumnTypeScanType = resolvingRows{}
+)
+
+func (c resolvingConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.conn.Prepare(query)
+	if err != nil {
+		return nil, c.resolve(err)
+	}
+	return resolvingStmt{stmt: stmt, resolve: c.resolve}, nil
+}
+
+func (c resolvingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	p, ok := c.conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	stmt, err := p.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, c.resolve(err)
+	}
+	return resolvingStmt{stmt: stmt, resolve: c.resolve}, nil
+}
+
+func (c resolvingConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c resolvingConn) Begin() (driver.Tx, error) {
+	tx, err := c.conn.Begin()
+	if err != nil {
+		return nil, c.resolve(err)
+	}
+	return resolvingTx{tx: tx, resolve: c.resolve}, nil
+}
+
+func (c resolvingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	b, ok := c.conn.(driver.ConnBeginTx)
+	if !ok {
+		return c.Begin()
+	}
+	tx, err := b.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, c.resolve(err)
+	}
+	return resolvingTx{tx: tx, resolve: c.resolve}, nil
+}
+
+func (c resolvingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	result, err := e.ExecContext(ctx, query, args)
+	if err != nil {
+		return nil, c.resolve(err)
+	}
+	return result, nil
+}
+
+func (c resolvingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, c.resolve(err)
+	}
+	return resolvingRows{rows: rows, resolve: c.resolve}, nil
+}
+
+func (c resolvingConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c resolvingConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c resolvingConn) IsValid() bool {
+	if v, ok := c.conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c resolvingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type resolvingTx struct {
+	tx      driver.Tx
+	resolve func(error) error
+}
+
+func (t resolvingTx) Commit() error {
+	return t.resolve(t.tx.Commit())
+}
+
+func (t resolvingTx) Rollback() error {
+	return t.resolve(t.tx.Rollback())
+}
+
+type resolvingStmt struct {
+	stmt    driver.Stmt
+	resolve func(error) error
+}
+
+func (s resolvingStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s resolvingStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s resolvingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	result, err := s.stmt.Exec(args)
+	if err != nil {
+		return nil, s.resolve(err)
+	}
+	return result, nil
+}
+
+func (s resolvingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.stmt.Query(args)
+	if err != nil {
+		return nil, s.resolve(err)
+	}
+	return resolvingRows{rows: rows, resolve: s.resolve}, nil
+}
+
+func (s resolvingStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := s.stmt.(driver.StmtExecContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(values)
+	}
+	result, err := e.ExecContext(ctx, args)
+	if err != nil {
+		return nil, s.resolve(err)
+	}
+	return result, nil
+}
+
+func (s resolvingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := s.stmt.(driver.StmtQueryContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Query(values)
+	}
+	rows, err := q.QueryContext(ctx, args)
+	if err != nil {
+		return nil, s.resolve(err)
+	}
+	return resolvingRows{rows: rows, resolve: s.resolve}, nil
+}
+
+func (s resolvingStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, driver.ErrSkip
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+// resolvingRows wraps driver.Rows; errors raised after the first result
+// set has started are returned from Next/NextResultSet
+type resolvingRows struct {
+	rows    driver.Rows
+	resolve func(error) error
+}
+
+func (r resolvingRows) Columns() []string {
+	return r.rows.Columns()
+}
+
+func (r resolvingRows) Close() error {
+	return r.resolve(r.rows.Close())
+}
+
+func (r resolvingRows) Next(dest []driver.Value) error {
+	// io.EOF passes through ResolveError unchanged
+	return r.resolve(r.rows.Next(dest))
+}
+
+func (r resolvingRows) HasNextResultSet() bool {
+	if n, ok := r.rows.(driver.RowsNextResultSet); ok {
+		return n.HasNextResultSet()
+	}
+	return false
+}
+
+func (r resolvingRows) NextResultSet() error {
+	if n, ok := r.rows.(driver.RowsNextResultSet); ok {
+		return r.resolve(n.NextResultSet())
+	}
+	return io.EOF
+}
+
+func (r resolvingRows) ColumnTypeScanType(index int) reflect.Type {
+	if c, ok := r.rows.(driver.RowsColumnTypeScanType); ok {
+		return c.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r resolvingRows) ColumnTypeDatabaseTypeName(index int) string {
+	if c, ok := r.rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return c.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r resolvingRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if c, ok := r.rows.(driver.RowsColumnTypeLength); ok {
+		return c.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r resolvingRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if c, ok := r.rows.(driver.RowsColumnTypeNullable); ok {
+		return c.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r resolvingRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if c, ok := r.rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return c.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
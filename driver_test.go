@@ -0,0 +1,64 @@
+package sqlcode
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingConnector returns connections that fail every statement with err
+type failingConnector struct {
+	err error
+}
+
+func (c failingConnector) Connect(context.Context) (driver.Conn, error) {
+	return failingConn(c), nil
+}
+
+func (c failingConnector) Driver() driver.Driver {
+	return nil
+}
+
+type failingConn struct {
+	err error
+}
+
+func (c failingConn) Prepare(string) (driver.Stmt, error) { return nil, c.err }
+func (c failingConn) Close() error                        { return nil }
+func (c failingConn) Begin() (driver.Tx, error)           { return nil, c.err }
+
+func TestConnector(t *testing.T) {
+	fs := fstest.MapFS{
+		"test.sql": &fstest.MapFile{Data: []byte(`create procedure [code].Fail as begin
+    throw 50000, 'oops', 1
+end
+`)},
+	}
+	d, err := Include(Options{}, fs)
+	require.NoError(t, err)
+
+	dbi := sql.OpenDB(d.Connector(failingConnector{err: mssql.Error{Number: 50000, Message: "oops", ProcName: "Fail", LineNo: 2}}))
+	defer dbi.Close()
+
+	_, err = dbi.ExecContext(context.Background(), "[code].Fail")
+	var runtimeErr SQLRuntimeError
+	require.True(t, errors.As(err, &runtimeErr))
+	assert.Equal(t, "test.sql:2:1 ([Fail]): oops", err.Error())
+
+	_, err = dbi.QueryContext(context.Background(), "[code].Fail")
+	assert.True(t, errors.As(err, &runtimeErr))
+
+	// Other errors are passed through
+	plain := errors.New("plain")
+	dbi = sql.OpenDB(d.Connector(failingConnector{err: plain}))
+	defer dbi.Close()
+	_, err = dbi.ExecContext(context.Background(), "select 1")
+	assert.Equal(t, plain, err)
+}
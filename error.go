@@ -43,3 +43,39 @@ func (e SQLCodeParseErrors) Error() string {
 	}
 	return msg.String()
 }
+
+// SQLRuntimeError is an mssql.Error raised when executing code uploaded by
+// sqlcode, with the error positions mapped back to the source files.
+// See Deployable.ResolveError.
+type SQLRuntimeError struct {
+	Wrapped mssql.Error
+	// Items has one entry for each item in Wrapped.All
+	Items []SQLRuntimeErrorItem
+}
+
+// SQLRuntimeErrorItem describes where in the source an item of
+// mssql.Error.All was raised. It is the zero value for items that
+// were not raised by sqlcode procedures/functions.
+type SQLRuntimeErrorItem struct {
+	Pos        sqlparser.Pos
+	Namespace  string // "" for [code]
+	QuotedName string // name of the procedure/function as declared in the source
+	Docstring  string
+}
+
+func (s SQLRuntimeError) Error() string {
+	var lines []string
+	for i, item := range s.Wrapped.All {
+		source := s.Items[i]
+		if source.Pos.File == "" {
+			lines = append(lines, fmt.Sprintf("(%s:%d): %s", item.ProcName, item.LineNo, item.Message))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s:%d:%d (%s): %s", source.Pos.File, source.Pos.Line, source.Pos.Col, source.QuotedName, item.Message))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (s SQLRuntimeError) Unwrap() error {
+	return s.Wrapped
+}
@@ -5,6 +5,7 @@ package example
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"testing"
 	"time"
@@ -24,21 +25,24 @@ func TestPreprocess(t *testing.T) {
 
 var sqlPatchedBeforeUpload = SQL.Patch(`select [code].AddTwoNumbers(2, 3)`)
 
+func TestMain(m *testing.M) {
+	sqltest.Main(m)
+}
+
 func TestCallSqlCode(t *testing.T) {
-	fixture := sqltest.NewFixture()
-	defer fixture.Teardown()
-	fixture.RunMigrationFile("../migrations/0001.sqlcode.sql")
+	t.Parallel()
+	fixture := sqltest.SharedFixture(t, &SQL)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 
-	require.NoError(t, SQL.EnsureUploaded(ctx, fixture.DB))
-
 	sqlPatchedAfterUpload := SQL.Patch(`select [code].AddTwoNumbers(2, 3)`)
 
-	var x int
-	require.NoError(t, fixture.DB.QueryRowContext(ctx, sqlPatchedBeforeUpload).Scan(&x))
-	assert.Equal(t, 5, x)
-	require.NoError(t, fixture.DB.QueryRowContext(ctx, sqlPatchedAfterUpload).Scan(&x))
-	assert.Equal(t, 5, x)
+	fixture.WithTx(t, func(tx *sql.Tx) {
+		var x int
+		require.NoError(t, tx.QueryRowContext(ctx, sqlPatchedBeforeUpload).Scan(&x))
+		assert.Equal(t, 5, x)
+		require.NoError(t, tx.QueryRowContext(ctx, sqlPatchedAfterUpload).Scan(&x))
+		assert.Equal(t, 5, x)
+	})
 }
@@ -0,0 +1,118 @@
+package sqlcode
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+// GoResultSetStructs generates Go source for a package with a struct per
+// procedure with a result set declared in its docstring (see
+// sqlparser.ResultSet); e.g. `ListOrdersRow` for `[code].ListOrders`, with
+// the fields in the order of the columns and `db:"..."` tags with the column
+// names, as read by sqltest.QueryStructs. Nullable columns are sql.Null[T].
+func (d Deployable) GoResultSetStructs(packageName string) ([]byte, error) {
+	var body bytes.Buffer
+	imports := make(map[string]bool)
+	for _, c := range d.CodeBase.Creates {
+		declared, err := c.ResultSet()
+		if err != nil {
+			return nil, err
+		}
+		if declared == nil {
+			continue
+		}
+		structName := goIdentifier(unquoteName(c.QuotedName.Value)) + "Row"
+		if c.Namespace != "" {
+			structName = goIdentifier(c.Namespace) + structName
+		}
+		fmt.Fprintf(&body, "\n// %s is a row of the result set of %s\ntype %s struct {\n", structName, c.QualifiedName(), structName)
+		for _, col := range declared.Columns {
+			goType, pkgs := goTypeOf(col)
+			for _, pkg := range pkgs {
+				imports[pkg] = true
+			}
+			fmt.Fprintf(&body, "\t%s %s `db:%q`\n", goIdentifier(col.Name), goType, col.Name)
+		}
+		body.WriteString("}\n")
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by sqlcode; DO NOT EDIT.\n\npackage %s\n", packageName)
+	if len(imports) > 0 {
+		var pkgs []string
+		for pkg := range imports {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		src.WriteString("\nimport (\n")
+		for _, pkg := range pkgs {
+			fmt.Fprintf(&src, "\t%q\n", pkg)
+		}
+		src.WriteString(")\n")
+	}
+	src.Write(body.Bytes())
+	return format.Source(src.Bytes())
+}
+
+// goTypeOf is the Go type to scan a column into, and the packages it needs
+func goTypeOf(col sqlparser.ResultSetColumn) (goType string, pkgs []string) {
+	baseType := strings.ToLower(strings.TrimSpace(col.Type))
+	if i := strings.IndexByte(baseType, '('); i >= 0 {
+		baseType = strings.TrimSpace(baseType[:i])
+	}
+	switch baseType {
+	case "bigint":
+		goType = "int64"
+	case "int":
+		goType = "int32"
+	case "smallint":
+		goType = "int16"
+	case "tinyint":
+		goType = "uint8"
+	case "bit":
+		goType = "bool"
+	case "float":
+		goType = "float64"
+	case "real":
+		goType = "float32"
+	case "date", "datetime", "datetime2", "smalldatetime", "datetimeoffset", "time":
+		goType, pkgs = "time.Time", []string{"time"}
+	case "binary", "varbinary", "image", "rowversion", "timestamp":
+		// nil for NULL
+		return "[]byte", nil
+	default:
+		// (n)(var)char, decimal, money, uniqueidentifier, xml, ...
+		goType = "string"
+	}
+	if col.Nullable {
+		return "sql.Null[" + goType + "]", append(pkgs, "database/sql")
+	}
+	return goType, pkgs
+}
+
+// goIdentifier makes an exported Go identifier of a name, e.g. `order_id` -> `OrderId`
+func goIdentifier(name string) string {
+	var result strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		result.WriteRune(r)
+	}
+	if result.Len() == 0 || unicode.IsDigit([]rune(result.String())[0]) {
+		return "X" + result.String()
+	}
+	return result.String()
+}
@@ -0,0 +1,545 @@
+// Package httpapi exposes the procedures of a sqlcode.Deployable as HTTP
+// endpoints.
+//
+// A procedure is exposed if it is named after the endpoint,
+//
+//	create procedure [code].[GET:/orders/{orderID}] (@orderID bigint) as ...
+//
+// or has an `http` key in its YAML docstring:
+//
+//	--! http: GET /orders/{orderID}
+//	--! timeoutMs: 400
+//	create procedure [code].GetOrder (@orderID bigint) as ...
+//
+// Patterns are those of net/http.ServeMux. The parameters of the procedure
+// are bound by name (case-insensitive) to path wildcards, query parameters
+// and the fields of a JSON object in the request body, in that order;
+// parameters with defaults may be left out. Every path wildcard must name
+// a parameter. `timeoutMs` in the docstring limits how long the procedure
+// may run.
+//
+// Output of `for json` is streamed to the client as it is read from the
+// database, and the response is aborted if reading fails; other result sets are returned as a JSON array of objects, with
+// UNIQUEIDENTIFIER formatted as a string, DECIMAL and MONEY as strings and
+// binary columns base64 encoded.
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/vippsas/sqlcode"
+	"github.com/vippsas/sqlcode/sqlparser"
+	"gopkg.in/yaml.v3"
+)
+
+// forJSONColumn is the name of the column SQL Server returns the output of
+// `for json` in, split over several rows
+const forJSONColumn = "JSON_F52E2B61-18A1-11d1-B105-00805F49916B"
+
+// Route is an endpoint served by Handler
+type Route struct {
+	Pattern   string // as for http.ServeMux, e.g. `GET /orders/{orderID}`
+	Procedure string // e.g. `[GET:/orders/{orderID}]`, as in sqlparser.Create.QualifiedName
+	Timeout   time.Duration
+	Params    []sqlparser.Parameter
+
+	call      string            // the name to call the procedure by, e.g. `[code@abc].[Foo]`
+	wildcards map[string]string // the path wildcards by the (lower-case) parameter names they bind to
+}
+
+// Options configures a Handler
+type Options struct {
+	// ErrorHandler writes the response when a request fails; the default
+	// writes `{"error": "..."}` with a status code from StatusCode
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+	// LogError is called with the errors the client is not told about: those
+	// the default ErrorHandler answers with 500 Internal Server Error, and
+	// those after the output of `for json` has started, when the response
+	// is aborted
+	LogError func(r *http.Request, err error)
+}
+
+// Handler serves the procedures of a Deployable as HTTP endpoints; see New
+type Handler struct {
+	d      sqlcode.Deployable
+	dbc    sqlcode.DB
+	opts   Options
+	mux    *http.ServeMux
+	routes []Route
+}
+
+var _ http.Handler = &Handler{}
+
+var nameRegexp = regexp.MustCompile(`^\[([A-Z]+):(/[^\]]*)\]$`)
+
+var wildcardRegexp = regexp.MustCompile(`\{([^}]*)\}`)
+
+// BadRequestError is returned when the request can not be bound to the
+// parameters of the procedure
+type BadRequestError struct {
+	Message string
+}
+
+func (e BadRequestError) Error() string {
+	return e.Message
+}
+
+// New creates a Handler for the procedures of d that are exposed as endpoints
+// (see the package documentation), calling them on dbc. The code should be
+// uploaded (see Deployable.EnsureUploaded) before requests are served.
+func New(d sqlcode.Deployable, dbc sqlcode.DB, opts Options) (h *Handler, err error) {
+	h = &Handler{d: d, dbc: dbc, opts: opts, mux: http.NewServeMux()}
+	for _, c := range d.CodeBase.Creates {
+		route, ok, err := routeOf(c)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d:%d: %s: %w", c.QuotedName.File, c.QuotedName.Line, c.QuotedName.Col, c.QualifiedName(), err)
+		}
+		if !ok {
+			continue
+		}
+		route.call = d.Patch(route.call)
+		if err := h.handle(route); err != nil {
+			return nil, fmt.Errorf("%s:%d:%d: %s: %w", c.QuotedName.File, c.QuotedName.Line, c.QuotedName.Col, c.QualifiedName(), err)
+		}
+		h.routes = append(h.routes, route)
+	}
+	return h, nil
+}
+
+// Routes lists the endpoints served
+func (h *Handler) Routes() []Route {
+	return append([]Route(nil), h.routes...)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// handle adds the route to the mux; ServeMux panics on conflicting patterns
+func (h *Handler) handle(route Route) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	h.mux.HandleFunc(route.Pattern, func(w http.ResponseWriter, r *http.Request) {
+		err := h.serve(w, r, route)
+		var started responseStartedError
+		if errors.As(err, &started) {
+			h.logError(r, started.err)
+			// makes net/http close the connection, so that the client sees
+			// the response is incomplete
+			panic(http.ErrAbortHandler)
+		}
+		if err != nil {
+			h.error(w, r, err)
+		}
+	})
+	return nil
+}
+
+// routeOf finds the route of a procedure; ok is false if it is not exposed
+func routeOf(c sqlparser.Create) (route Route, ok bool, err error) {
+	if c.CreateType != "procedure" || c.IsTestProcedure() {
+		return Route{}, false, nil
+	}
+	var doc struct {
+		HTTP      yaml.Node `yaml:"http"`
+		TimeoutMs int       `yaml:"timeoutMs"`
+	}
+	if err := c.ParseYamlInDocstring(&doc); err != nil {
+		return Route{}, false, err
+	}
+
+	switch {
+	case !doc.HTTP.IsZero():
+		var pattern string
+		if err := doc.HTTP.Decode(&pattern); err != nil {
+			var endpoint struct {
+				Method string `yaml:"method"`
+				Path   string `yaml:"path"`
+			}
+			if err := doc.HTTP.Decode(&endpoint); err != nil {
+				return Route{}, false, errors.New("http: should be `METHOD /path` or {method: METHOD, path: /path}")
+			}
+			pattern = strings.TrimSpace(strings.ToUpper(endpoint.Method) + " " + endpoint.Path)
+		}
+		route.Pattern = pattern
+	default:
+		m := nameRegexp.FindStringSubmatch(c.QuotedName.Value)
+		if m == nil {
+			return Route{}, false, nil
+		}
+		route.Pattern = m[1] + " " + m[2]
+	}
+
+	route.Procedure = c.QualifiedName()
+	route.call = "[code]." + c.QuotedName.Value
+	if c.Namespace != "" {
+		route.call = "[" + c.Namespace + "]." + c.QuotedName.Value
+	}
+	route.Timeout = time.Duration(doc.TimeoutMs) * time.Millisecond
+	for _, p := range c.Parameters() {
+		switch {
+		case p.ReadOnly:
+			return Route{}, false, fmt.Errorf("table-valued parameter %s is not supported", p.Name)
+		case p.Output && !p.HasDefault:
+			return Route{}, false, fmt.Errorf("output parameter %s is not supported", p.Name)
+		case p.Output:
+			continue
+		}
+		route.Params = append(route.Params, p)
+	}
+
+	// Request.PathValue is case-sensitive, so the wildcards are matched
+	// with the parameters here
+	route.wildcards = make(map[string]string)
+	for _, m := range wildcardRegexp.FindAllStringSubmatch(route.Pattern, -1) {
+		wildcard := strings.TrimSuffix(m[1], "...")
+		if wildcard == "$" {
+			continue
+		}
+		found := false
+		for _, p := range route.Params {
+			name := strings.TrimPrefix(p.Name, "@")
+			if strings.EqualFold(name, wildcard) {
+				route.wildcards[strings.ToLower(name)] = wildcard
+				found = true
+			}
+		}
+		if !found {
+			return Route{}, false, fmt.Errorf("path wildcard {%s} is not a parameter", wildcard)
+		}
+	}
+	return route, true, nil
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, route Route) error {
+	args, err := bind(r, route)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	if route.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, route.Timeout)
+		defer cancel()
+	}
+
+	call := "exec " + route.call
+	var names []string
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sqlArgs []interface{}
+	for i, name := range names {
+		if i > 0 {
+			call += ","
+		}
+		call += fmt.Sprintf(" @%s = @%s", name, name)
+		sqlArgs = append(sqlArgs, sql.Named(name, args[name]))
+	}
+
+	rows, err := h.dbc.QueryContext(ctx, call, sqlArgs...)
+	if err != nil {
+		return h.d.ResolveError(err)
+	}
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(columns) == 1 && columns[0] == forJSONColumn {
+		started, err := streamJSON(w, rows)
+		if err != nil && started {
+			return responseStartedError{h.d.ResolveError(err)}
+		}
+		return h.d.ResolveError(err)
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	return h.d.ResolveError(writeRows(w, rows, columns, types))
+}
+
+// responseStartedError is an error after the response has started, which
+// can not be reported to the client
+type responseStartedError struct {
+	err error
+}
+
+func (e responseStartedError) Error() string {
+	return e.err.Error()
+}
+
+// streamJSON writes the output of `for json`, which comes in several rows;
+// `started` tells whether anything was written when an error is returned
+func streamJSON(w http.ResponseWriter, rows *sql.Rows) (started bool, err error) {
+	for rows.Next() {
+		var chunk string
+		if err := rows.Scan(&chunk); err != nil {
+			return started, err
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if _, err := io.WriteString(w, chunk); err != nil {
+			return started, err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return started, err
+	}
+	if !started {
+		// `for json` on no rows gives no output
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, "[]")
+	}
+	return started, nil
+}
+
+// writeRows writes a result set as a JSON array of objects
+func writeRows(w http.ResponseWriter, rows *sql.Rows, columns []string, types []*sql.ColumnType) error {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for n := 0; rows.Next(); n++ {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		if err := rows.Scan(values...); err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			row[column] = jsonValue(types[i].DatabaseTypeName(), *(values[i].(*interface{})))
+		}
+		encoded, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			buf.WriteString(",")
+		}
+		buf.Write(encoded)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	buf.WriteString("]")
+	w.Header().Set("Content-Type", "application/json")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// jsonValue converts the values the driver returns as []byte: UNIQUEIDENTIFIER
+// is formatted as usual, and DECIMAL and MONEY become strings to keep their
+// precision. Binary values are left to encoding/json, which base64 encodes them.
+func jsonValue(databaseType string, v interface{}) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+	switch databaseType {
+	case "UNIQUEIDENTIFIER":
+		var id mssql.UniqueIdentifier
+		if err := id.Scan(b); err == nil {
+			return id.String()
+		}
+	case "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
+		return string(b)
+	}
+	return b
+}
+
+// bind finds the values of the parameters in the request; parameters not
+// given (that have defaults) are left out
+func bind(r *http.Request, route Route) (map[string]interface{}, error) {
+	var body map[string]json.RawMessage
+	if r.Body != nil && r.ContentLength != 0 && (r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			return nil, BadRequestError{"the body should be a JSON object: " + err.Error()}
+		}
+	}
+	query := r.URL.Query()
+
+	result := make(map[string]interface{})
+	for _, p := range route.Params {
+		name := strings.TrimPrefix(p.Name, "@")
+		if wildcard, ok := route.wildcards[strings.ToLower(name)]; ok {
+			v, err := fromString(p, r.PathValue(wildcard))
+			if err != nil {
+				return nil, err
+			}
+			result[name] = v
+			continue
+		}
+		if s, ok := lookupFold(query, name); ok {
+			v, err := fromString(p, s)
+			if err != nil {
+				return nil, err
+			}
+			result[name] = v
+			continue
+		}
+		if raw, ok := lookupBody(body, name); ok {
+			v, err := fromJSON(p, raw)
+			if err != nil {
+				return nil, err
+			}
+			result[name] = v
+			continue
+		}
+		if !p.HasDefault {
+			return nil, BadRequestError{"missing parameter " + name}
+		}
+	}
+	return result, nil
+}
+
+func lookupFold(query map[string][]string, name string) (string, bool) {
+	for key, values := range query {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0], true
+		}
+	}
+	return "", false
+}
+
+func lookupBody(body map[string]json.RawMessage, name string) (json.RawMessage, bool) {
+	for key, value := range body {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// kindOf classifies the SQL type of a parameter for binding
+func kindOf(p sqlparser.Parameter) string {
+	switch strings.ToLower(p.Type.BaseType) {
+	case "bigint", "int", "smallint", "tinyint":
+		return "int"
+	case "bit":
+		return "bool"
+	case "float", "real":
+		return "float"
+	}
+	return "string"
+}
+
+func fromString(p sqlparser.Parameter, s string) (interface{}, error) {
+	name := strings.TrimPrefix(p.Name, "@")
+	switch kindOf(p) {
+	case "int":
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, BadRequestError{fmt.Sprintf("parameter %s should be an integer", name)}
+		}
+		return v, nil
+	case "bool":
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, BadRequestError{fmt.Sprintf("parameter %s should be true or false", name)}
+		}
+		return v, nil
+	case "float":
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, BadRequestError{fmt.Sprintf("parameter %s should be a number", name)}
+		}
+		return v, nil
+	}
+	return s, nil
+}
+
+func fromJSON(p sqlparser.Parameter, raw json.RawMessage) (interface{}, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, BadRequestError{err.Error()}
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return fromString(p, v)
+	case json.Number:
+		return fromString(p, v.String())
+	case bool:
+		if kindOf(p) == "string" {
+			return strconv.FormatBool(v), nil
+		}
+		return v, nil
+	}
+	// objects and arrays are passed as JSON, e.g. for openjson()
+	return string(raw), nil
+}
+
+// StatusCode is the HTTP status code for an error from serving a request:
+// 400 for BadRequestError and errors raised with `throw` (error numbers
+// 50000 and up), 504 on timeout, otherwise 500
+func StatusCode(err error) int {
+	var badRequest BadRequestError
+	var sqlErr mssql.Error
+	switch {
+	case errors.As(err, &badRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &sqlErr) && sqlErr.Number >= 50000:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	if h.opts.ErrorHandler != nil {
+		h.opts.ErrorHandler(w, r, err)
+		return
+	}
+	status := StatusCode(err)
+	if status == http.StatusInternalServerError {
+		h.logError(r, err)
+	}
+	message := http.StatusText(status)
+	var badRequest BadRequestError
+	var sqlErr mssql.Error
+	if errors.As(err, &badRequest) {
+		message = badRequest.Message
+	} else if errors.As(err, &sqlErr) && sqlErr.Number >= 50000 {
+		message = sqlErr.Message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func (h *Handler) logError(r *http.Request, err error) {
+	if h.opts.LogError != nil {
+		h.opts.LogError(r, err)
+	}
+}
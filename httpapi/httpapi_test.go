@@ -0,0 +1,216 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode"
+	"github.com/vippsas/sqlcode/sqlcodetest"
+)
+
+var apiFS = fstest.MapFS{
+	"api.sql": &fstest.MapFile{Data: []byte(`
+create procedure [code].[GET:/orders/{orderID}] (@orderID bigint, @details bit = 0) as
+select OrderID, Comment from dbo.Orders where OrderID = @orderID for json path
+go
+--! http: {method: post, path: /orders}
+--! timeoutMs: 50
+create procedure [code].CreateOrder (@customerID bigint, @lines nvarchar(max), @comment nvarchar(100) = null) as
+select 1 as OrderID
+go
+create procedure [code].NotExposed as select 1
+`)},
+}
+
+func newTestHandler(t *testing.T) (*Handler, *sqlcodetest.Fake, sqlcode.Deployable) {
+	d, err := sqlcode.Include(sqlcode.Options{}, apiFS)
+	require.NoError(t, err)
+	fake := sqlcodetest.New()
+	h, err := New(d, fake.DB(), Options{})
+	require.NoError(t, err)
+	return h, fake, d
+}
+
+func do(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRoutes(t *testing.T) {
+	h, _, d := newTestHandler(t)
+	var patterns []string
+	for _, r := range h.Routes() {
+		patterns = append(patterns, r.Pattern+" -> "+r.Procedure)
+	}
+	assert.Equal(t, []string{
+		"GET /orders/{orderID} -> [GET:/orders/{orderID}]",
+		"POST /orders -> [CreateOrder]",
+	}, patterns)
+	assert.Equal(t, 50*time.Millisecond, h.Routes()[1].Timeout)
+	assert.Equal(t, "[code@"+d.SchemaSuffix+"].[CreateOrder]", h.Routes()[1].call)
+}
+
+func TestGetStreamsForJSON(t *testing.T) {
+	h, fake, d := newTestHandler(t)
+	fake.Respond("[GET:/orders/{orderID}]", []string{forJSONColumn},
+		[]interface{}{`[{"OrderID":12,"Comm`},
+		[]interface{}{`ent":"hi"}]`},
+	)
+
+	w := do(h, "GET", "/orders/12?Details=true", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `[{"OrderID":12,"Comment":"hi"}]`, w.Body.String())
+
+	statements := fake.Statements()
+	last := statements[len(statements)-1]
+	assert.Equal(t, "exec [code@"+d.SchemaSuffix+"].[GET:/orders/{orderID}] @details = @details, @orderID = @orderID", last.Query)
+	assert.Equal(t, map[string]interface{}{"orderid": int64(12), "details": true}, last.Args)
+
+	w = do(h, "GET", "/orders/twelve", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"error":"parameter orderID should be an integer"}`+"\n", w.Body.String())
+}
+
+func TestPostBindsJSONBody(t *testing.T) {
+	h, fake, _ := newTestHandler(t)
+	fake.Respond("[CreateOrder]", []string{"OrderID"}, []interface{}{int64(7)})
+
+	w := do(h, "POST", "/orders", `{"customerID": 3, "lines": [{"sku": "a"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[{"OrderID":7}]`, w.Body.String())
+	statements := fake.Statements()
+	assert.Equal(t, map[string]interface{}{"customerid": int64(3), "lines": `[{"sku": "a"}]`}, statements[len(statements)-1].Args)
+
+	w = do(h, "POST", "/orders", `{"lines": "[]"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"error":"missing parameter customerID"}`+"\n", w.Body.String())
+
+	w = do(h, "GET", "/orders", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestRowsByColumnType(t *testing.T) {
+	h, fake, _ := newTestHandler(t)
+	// as returned by the driver; UNIQUEIDENTIFIER has the first groups byte-swapped
+	fake.RespondTyped("[CreateOrder]",
+		[]string{"OrderID", "ExternalID", "Amount", "Signature", "Comment"},
+		[]string{"BIGINT", "UNIQUEIDENTIFIER", "DECIMAL", "VARBINARY", "NVARCHAR"},
+		[]interface{}{
+			int64(7),
+			[]byte{0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+			[]byte("12.50"),
+			[]byte{0xde, 0xad, 0xbe, 0xef},
+			"hi",
+		})
+
+	w := do(h, "POST", "/orders", `{"customerID": 3, "lines": "[]"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[{"Amount":"12.50","Comment":"hi","ExternalID":"00112233-4455-6677-8899-AABBCCDDEEFF","OrderID":7,"Signature":"3q2+7w=="}]`, w.Body.String())
+}
+
+func TestErrors(t *testing.T) {
+	h, fake, _ := newTestHandler(t)
+	fake.FailOnce("[CreateOrder]", mssql.Error{Number: 55010, Message: "Unknown customer"})
+	w := do(h, "POST", "/orders", `{"customerID": 3, "lines": "[]"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"error":"Unknown customer"}`+"\n", w.Body.String())
+
+	fake.FailOnce("[CreateOrder]", mssql.Error{Number: 208, Message: "Invalid object name 'dbo.Orders'."})
+	w = do(h, "POST", "/orders", `{"customerID": 3, "lines": "[]"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, `{"error":"Internal Server Error"}`+"\n", w.Body.String())
+
+	assert.Equal(t, http.StatusGatewayTimeout, StatusCode(context.DeadlineExceeded))
+}
+
+func TestLogError(t *testing.T) {
+	h, fake, _ := newTestHandler(t)
+	var logged []error
+	h.opts.LogError = func(r *http.Request, err error) {
+		logged = append(logged, err)
+	}
+
+	fake.FailOnce("[CreateOrder]", mssql.Error{Number: 55010, Message: "Unknown customer"})
+	do(h, "POST", "/orders", `{"customerID": 3, "lines": "[]"}`)
+	assert.Empty(t, logged)
+
+	fake.FailOnce("[CreateOrder]", mssql.Error{Number: 208, Message: "Invalid object name 'dbo.Orders'."})
+	do(h, "POST", "/orders", `{"customerID": 3, "lines": "[]"}`)
+	require.Len(t, logged, 1)
+	assert.Contains(t, logged[0].Error(), "Invalid object name 'dbo.Orders'.")
+}
+
+func TestStreamJSONAbortsOnError(t *testing.T) {
+	h, fake, _ := newTestHandler(t)
+	var logged []error
+	h.opts.LogError = func(r *http.Request, err error) {
+		logged = append(logged, err)
+	}
+	// the NULL can not be scanned, after the first chunk is written
+	fake.Respond("[GET:/orders/{orderID}]", []string{forJSONColumn},
+		[]interface{}{`[{"OrderID":12,"Comm`},
+		[]interface{}{nil},
+	)
+
+	w := httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/orders/12", nil))
+	})
+	assert.Equal(t, `[{"OrderID":12,"Comm`, w.Body.String())
+	require.Len(t, logged, 1)
+}
+
+func TestPathWildcards(t *testing.T) {
+	d, err := sqlcode.Include(sqlcode.Options{}, fstest.MapFS{
+		"a.sql": &fstest.MapFile{Data: []byte(`--! http: GET /customers/{CustomerId}/files/{path...}
+create procedure [code].GetFile (@customerID bigint, @Path nvarchar(400)) as select 1
+`)},
+	})
+	require.NoError(t, err)
+	fake := sqlcodetest.New()
+	h, err := New(d, fake.DB(), Options{})
+	require.NoError(t, err)
+
+	w := do(h, "GET", "/customers/3/files/a/b.txt", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	statements := fake.Statements()
+	assert.Equal(t, map[string]interface{}{"customerid": int64(3), "path": "a/b.txt"}, statements[len(statements)-1].Args)
+
+	d, err = sqlcode.Include(sqlcode.Options{}, fstest.MapFS{
+		"a.sql": &fstest.MapFile{Data: []byte(`--! http: GET /customers/{customer}
+create procedure [code].GetCustomer (@customerID bigint) as select 1
+`)},
+	})
+	require.NoError(t, err)
+	_, err = New(d, fake.DB(), Options{})
+	assert.EqualError(t, err, "a.sql:2:25: [GetCustomer]: path wildcard {customer} is not a parameter")
+}
+
+func TestConflictingRoutes(t *testing.T) {
+	d, err := sqlcode.Include(sqlcode.Options{}, fstest.MapFS{
+		"a.sql": &fstest.MapFile{Data: []byte(`create procedure [code].[GET:/a] as select 1
+go
+--! http: GET /a
+create procedure [code].A as select 1
+`)},
+	})
+	require.NoError(t, err)
+	_, err = New(d, sqlcodetest.New().DB(), Options{})
+	assert.ErrorContains(t, err, "a.sql:4:25: [A]: pattern \"GET /a\"")
+}
@@ -0,0 +1,259 @@
+package sqlcode
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is one of the SQL scripts in migrations/ that install the
+// sqlcode SQL library in a database
+type Migration struct {
+	Name    string // e.g. "0001.sqlcode.sql"
+	Version int
+	Batches []string
+}
+
+// Migrations returns the migrations of the sqlcode SQL library, in the
+// order they should be applied
+func Migrations() ([]Migration, error) {
+	// ReadDir returns the entries sorted by filename
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var result []Migration
+	for _, entry := range entries {
+		version, err := strconv.Atoi(strings.SplitN(entry.Name(), ".", 2)[0])
+		if err != nil {
+			return nil, err
+		}
+		buf, err := fs.ReadFile(migrationFiles, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		batches, err := sqlparser.SplitBatches(sqlparser.FileRef(entry.Name()), string(buf))
+		if err != nil {
+			return nil, err
+		}
+		if marker, ok := batchStarts[entry.Name()]; ok {
+			batches = splitBatchesAt(batches, marker)
+		}
+		result = append(result, Migration{
+			Name:    entry.Name(),
+			Version: version,
+			Batches: batches,
+		})
+	}
+	return result, nil
+}
+
+// batchStarts has statements that must start a batch, but do not in the
+// released migrations; in 0002, `create procedure` follows the `drop
+// procedure` in the first batch. The files are kept as released, since they
+// may have been applied as they are by other means.
+var batchStarts = map[string]string{
+	"0002.sqlcode.sql": "create procedure sqlcode.DropCodeSchema",
+}
+
+// splitBatchesAt starts a new batch where marker is found inside a batch
+func splitBatchesAt(batches []string, marker string) (result []string) {
+	for _, batch := range batches {
+		if i := strings.Index(batch, marker); i > 0 && strings.TrimSpace(batch[:i]) != "" {
+			result = append(result, batch[:i], batch[i:])
+			continue
+		}
+		result = append(result, batch)
+	}
+	return result
+}
+
+// LatestMigrationVersion is the version of the last of the Migrations
+func LatestMigrationVersion() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// LibraryVersionError is returned when the sqlcode library installed in
+// the database is missing or older than what is needed
+type LibraryVersionError struct {
+	Installed int // 0 if not installed
+	Required  int
+	Reason    string
+}
+
+func (e LibraryVersionError) Error() string {
+	if e.Installed == 0 {
+		return "the sqlcode library is not installed in the database; run `sqlcode install` or call sqlcode.InstallOrUpgrade"
+	}
+	return fmt.Sprintf("the sqlcode library installed in the database is version %d, but %s requires version %d; run `sqlcode install` or call sqlcode.InstallOrUpgrade to upgrade",
+		e.Installed, e.Reason, e.Required)
+}
+
+// installedVersionQuery finds the version of the sqlcode library. Databases
+// where the library was installed before migration 0005 (which added the
+// sqlcode.Version table) are recognized by what the migrations left behind.
+const installedVersionQuery = `
+declare @version int = 0;
+if object_id('sqlcode.Version') is not null
+    exec sp_executesql N'select @version = isnull(max(Version), 0) from sqlcode.Version', N'@version int output', @version = @version output;
+else if schema_id('sqlcode') is not null
+begin
+    declare @procs table (name sysname, definition nvarchar(max), hasNamespace bit);
+    insert into @procs
+    select o.name, m.definition, iif(exists (select 1 from sys.parameters p where p.object_id = o.object_id and p.name = '@namespace'), 1, 0)
+    from sys.objects o
+    join sys.sql_modules m on m.object_id = o.object_id
+    where o.schema_id = schema_id('sqlcode');
+
+    set @version = case
+        when exists (select 1 from @procs where name = 'AssertEquals') then 4
+        when exists (select 1 from @procs where name = 'CreateCodeSchema' and hasNamespace = 1) then 3
+        when exists (select 1 from @procs where name = 'DropCodeSchema' and definition like '%@curVFP%') then 2
+        when exists (select 1 from @procs where name = 'CreateCodeSchema') then 1
+        else 0
+    end;
+end
+select @version;
+`
+
+// deployerVersionQuery is installedVersionQuery for checking that the library
+// is recent enough before uploading, with only the permissions of
+// [sqlcode-deploy-role]: SELECT on sqlcode.Version, and for databases from
+// before migration 0005, EXECUTE on the procedures to see their parameters.
+// The definitions, which need VIEW DEFINITION, are not read, so version 2 is
+// reported as 1; no code requires version 2.
+const deployerVersionQuery = `
+declare @version int = 0;
+if object_id('sqlcode.Version') is not null
+    exec sp_executesql N'select @version = isnull(max(Version), 0) from sqlcode.Version', N'@version int output', @version = @version output;
+else if schema_id('sqlcode') is not null
+    set @version = case
+        when exists (select 1 from sys.objects o where o.schema_id = schema_id('sqlcode') and o.name = 'AssertEquals') then 4
+        when exists (
+            select 1 from sys.objects o
+            join sys.parameters p on p.object_id = o.object_id
+            where o.schema_id = schema_id('sqlcode') and o.name = 'CreateCodeSchema' and p.name = '@namespace'
+        ) then 3
+        when exists (select 1 from sys.objects o where o.schema_id = schema_id('sqlcode') and o.name = 'CreateCodeSchema') then 1
+        else 0
+    end;
+select @version;
+`
+
+// InstalledVersion returns the version of the sqlcode library installed in
+// the database, i.e. the last of the Migrations applied; 0 if it is not installed
+func InstalledVersion(ctx context.Context, dbc RowQuerier) (version int, err error) {
+	err = dbc.QueryRowContext(ctx, installedVersionQuery).Scan(&version)
+	return
+}
+
+// InstallOrUpgrade installs the sqlcode library in the database, or
+// upgrades it by applying the Migrations that are missing. Each migration is
+// applied in its own transaction, under an application lock, so that
+// concurrent calls do not apply the same migration twice. Needs db_owner or
+// equivalent permissions.
+func InstallOrUpgrade(ctx context.Context, dbc DB) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	installed, err := InstalledVersion(ctx, dbc)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Version <= installed {
+			continue
+		}
+		if err := applyMigration(ctx, dbc, m); err != nil {
+			return fmt.Errorf("while applying sqlcode migration %s: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, dbc DB, m Migration) error {
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = lockInTx(ctx, tx, "sqlcode.InstallOrUpgrade"); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	// Someone else may have applied it while we waited for the lock
+	installed, err := InstalledVersion(ctx, tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if installed >= m.Version {
+		return tx.Rollback()
+	}
+
+	for _, batch := range m.Batches {
+		if _, err = tx.ExecContext(ctx, batch); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+if object_id('sqlcode.Version') is not null
+    insert into sqlcode.Version (Version, Name) values (@version, @name);
+`,
+		sql.Named("version", m.Version),
+		sql.Named("name", m.Name),
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// requiredLibraryVersion is the version of the sqlcode library needed to
+// upload the receiver, and the feature that needs it
+func (d Deployable) requiredLibraryVersion() (version int, reason string) {
+	version, reason = 1, "sqlcode"
+	for _, c := range d.CodeBase.Creates {
+		if c.Test && version < 4 {
+			version, reason = 4, "SQL tests (sqlcode.AssertEquals)"
+		}
+	}
+	if len(d.CodeBase.Namespaces()) > 1 && version < 3 {
+		version, reason = 3, "using namespaces other than [code]"
+	}
+	return
+}
+
+// checkLibraryVersion returns a LibraryVersionError if the sqlcode library
+// in the database is missing or too old for the receiver
+func (d Deployable) checkLibraryVersion(ctx context.Context, dbc RowQuerier) error {
+	var installed int
+	err := dbc.QueryRowContext(ctx, deployerVersionQuery).Scan(&installed)
+	if err != nil {
+		return err
+	}
+	required, reason := d.requiredLibraryVersion()
+	if installed < required {
+		return LibraryVersionError{Installed: installed, Required: required, Reason: reason}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+-- Adds support for namespaces, i.e. virtual schemas other than [code] declared
+-- with the `--sqlcode:namespace` pragma. CreateCodeSchema and DropCodeSchema get
+-- an optional @namespace parameter; the schema name is then [<namespace>@<schemasuffix>].
+--
+-- As DropCodeSchema can now drop schemas with other names than [code@...], it
+-- validates the namespace like CreateCodeSchema, and refuses to drop schemas
+-- not owned by [sqlcode-user-with-no-permissions], i.e. not created by
+-- CreateCodeSchema.
+--
+-- Altering the procedures would remove the signatures, so drop and re-create
+-- them, and sign them with a new certificate in the end.
+
+drop procedure sqlcode.CreateCodeSchema;
+drop procedure sqlcode.DropCodeSchema;
+
+go
+
+create procedure sqlcode.CreateCodeSchema(@schemasuffix varchar(50), @namespace varchar(50) = 'code')
+as begin
+    set xact_abort, nocount on
+    begin try
+        declare @msg varchar(max)
+
+        if @@trancount = 0 throw 55001, 'You should run sqlcode.CreateCodeSchema within a transaction', 1;
+
+        if @namespace is null or @namespace = '' or @namespace = 'sqlcode' or charindex('@', @namespace) > 0
+        begin
+            set @msg = concat('Illegal namespace: ', @namespace);
+            throw 55003, @msg, 1;
+        end
+
+        declare @schemaname nvarchar(max) = concat(@namespace, '@', @schemasuffix);
+
+        -- Create the schema owned by [sqlcode-user-with-no-permissions] so that
+        -- no owner chaining will happen for stored procedures
+
+        declare @sql nvarchar(max) = concat('create schema ', quotename(@schemaname), ' authorization [sqlcode-user-with-no-permissions];')
+        exec sp_executesql @sql;
+
+        set @sql = concat('grant select, execute, references, view definition on schema::', quotename(@schemaname), ' to [sqlcode-execute-role];');
+        exec sp_executesql @sql;
+
+        set @sql = concat('grant alter, select, execute, references, view definition on schema::', quotename(@schemaname), ' to [sqlcode-deploy-role];');
+        exec sp_executesql @sql;
+
+    end try
+    begin catch
+        if @@trancount > 0 rollback;
+        ;throw
+    end catch
+end
+
+go
+
+create procedure sqlcode.DropCodeSchema(@schemasuffix varchar(50), @namespace varchar(50) = 'code')
+as begin
+    set xact_abort, nocount on
+    begin try
+        declare @msg varchar(max)
+        declare @sql nvarchar(max)
+
+        if @@trancount = 0 throw 55001, 'You should run sqlcode.DropCodeSchema within a transaction', 1;
+
+        if @namespace is null or @namespace = '' or @namespace = 'sqlcode' or charindex('@', @namespace) > 0
+        begin
+            set @msg = concat('Illegal namespace: ', @namespace);
+            throw 55003, @msg, 1;
+        end
+
+        declare @schemaname nvarchar(max) = concat(@namespace, '@', @schemasuffix)
+        declare @schemaid int = (select schema_id from sys.schemas where name = @schemaname);
+        if @schemaid is null
+        begin
+            set @msg = concat('Schema [', @schemaname, '] not found');
+            throw 55002, @msg, 1;
+        end
+
+        if (select principal_id from sys.schemas where schema_id = @schemaid) <> user_id('sqlcode-user-with-no-permissions')
+        begin
+            set @msg = concat('Schema [', @schemaname, '] was not created by sqlcode.CreateCodeSchema');
+            throw 55004, @msg, 1;
+        end
+
+        -- Drop views, functions, procedures
+        declare @curVFP cursor; -- VFP: views, functions, procedures
+        set @curVFP = cursor local read_only forward_only for
+            select
+                concat('drop ', v.DropType, ' ', quotename(@schemaname), '.', quotename(o.name))
+            from sys.objects as o
+            cross apply ( values ( case
+                when o.type = 'FN' then 'function'
+                when o.type = 'IF' then 'function'
+                when o.type = 'TF' then 'function'
+                when o.type = 'P' then 'procedure'
+                when o.type = 'PC' then 'procedure'
+                when o.type = 'V' then 'view'
+            end )) v(DropType)
+            where o.schema_id = @schemaid and v.DropType is not null;
+
+        open @curVFP
+
+        fetch next from @curVFP into @sql;
+        while (@@fetch_status = 0)
+        begin
+            exec sp_executesql @sql;
+            fetch next from @curVFP into @sql;
+        end
+
+        close @curVFP
+        deallocate @curVFP
+
+        -- Drop types
+        declare @curT cursor -- T: types
+        set @curT = cursor local read_only forward_only for
+            select
+                concat('drop type ', quotename(@schemaname), '.', quotename(t.name))
+            from sys.types as t
+            where t.schema_id = @schemaid;
+
+        open @curT
+
+        fetch next from @curT into @sql;
+        while (@@fetch_status = 0)
+        begin
+            exec sp_executesql @sql;
+            fetch next from @curT into @sql;
+        end
+
+        close @curT
+        deallocate @curT
+
+        -- Finally drop the schema itself
+        set @sql = concat('drop schema ', quotename(@schemaname))
+        exec sp_executesql @sql;
+
+    end try
+    begin catch
+        if @@trancount > 0 rollback;
+        ;throw
+    end catch
+end
+
+go
+
+create certificate [cert/sqlcode3] encryption by password = 'SqlCodePw1%' with subject = '"sqlcode3"';
+add signature to sqlcode.CreateCodeSchema by certificate [cert/sqlcode3]  with password = 'SqlCodePw1%'
+add signature to sqlcode.DropCodeSchema by certificate [cert/sqlcode3]  with password = 'SqlCodePw1%'
+
+create user [certuser/sqlcode3] from certificate [cert/sqlcode3] ;
+alter role db_owner add member [certuser/sqlcode3];
+
+alter certificate [cert/sqlcode3] remove private key;
+
+go
+
+grant execute on sqlcode.CreateCodeSchema to [sqlcode-deploy-role];
+grant execute on sqlcode.DropCodeSchema to [sqlcode-deploy-role];
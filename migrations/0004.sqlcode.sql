@@ -0,0 +1,57 @@
+-- Assertions for SQL tests run by `sqlcode test` / sqltest.RunSQLTests. A failing
+-- assertion throws, which fails the test.
+--
+-- Note that [code] is not replaced inside string literals; so to compare
+-- the results of sqlcode procedures/functions with AssertRowsetEquals,
+-- first store them in temporary tables, e.g.:
+--
+--   insert into #actual exec [code].MyProc;
+--   exec sqlcode.AssertRowsetEquals 'select * from #expected', 'select * from #actual';
+
+create procedure sqlcode.AssertEquals(@expected sql_variant, @actual sql_variant, @message nvarchar(2000) = null)
+as begin
+    set nocount on
+    declare @msg nvarchar(max)
+
+    if (@expected is null and @actual is null) or @expected = @actual return;
+
+    set @msg = concat(
+        'AssertEquals failed',
+        case when @message is not null then concat(' (', @message, ')') end,
+        ': expected ', isnull(convert(nvarchar(max), @expected), 'NULL'),
+        ', got ', isnull(convert(nvarchar(max), @actual), 'NULL'));
+    throw 55100, @msg, 1;
+end
+
+go
+
+-- AssertRowsetEquals compares the results of two queries as sets; i.e.
+-- row order and duplicate rows are not considered
+create procedure sqlcode.AssertRowsetEquals(@expected nvarchar(max), @actual nvarchar(max), @message nvarchar(2000) = null)
+as begin
+    set nocount on
+    declare @msg nvarchar(max)
+    declare @onlyExpected int
+    declare @onlyActual int
+    declare @sql nvarchar(max)
+
+    set @sql = concat('select @n = count(*) from ((', @expected, ') except (', @actual, ')) x');
+    exec sp_executesql @sql, N'@n int output', @n = @onlyExpected output;
+    set @sql = concat('select @n = count(*) from ((', @actual, ') except (', @expected, ')) x');
+    exec sp_executesql @sql, N'@n int output', @n = @onlyActual output;
+
+    if @onlyExpected = 0 and @onlyActual = 0 return;
+
+    set @msg = concat(
+        'AssertRowsetEquals failed',
+        case when @message is not null then concat(' (', @message, ')') end,
+        ': ', @onlyExpected, ' row(s) only in expected, ', @onlyActual, ' row(s) only in actual');
+    throw 55101, @msg, 1;
+end
+
+go
+
+grant execute on sqlcode.AssertEquals to [sqlcode-execute-role];
+grant execute on sqlcode.AssertRowsetEquals to [sqlcode-execute-role];
+grant execute on sqlcode.AssertEquals to [sqlcode-deploy-role];
+grant execute on sqlcode.AssertRowsetEquals to [sqlcode-deploy-role];
@@ -0,0 +1,20 @@
+-- Records which of the sqlcode migrations have been applied, so that
+-- sqlcode.InstallOrUpgrade knows where to continue, and EnsureUploaded can
+-- check that the library is recent enough for the code being uploaded.
+--
+-- InstallOrUpgrade inserts a row after applying each migration (from this
+-- one on); the migrations applied before the table existed are recorded here.
+
+create table sqlcode.Version (
+    Version int not null primary key,
+    Name varchar(100) not null,
+    AppliedAt datetime2 not null default sysutcdatetime()
+);
+
+go
+
+insert into sqlcode.Version (Version, Name)
+values (1, '0001.sqlcode.sql'), (2, '0002.sqlcode.sql'), (3, '0003.sqlcode.sql'), (4, '0004.sqlcode.sql');
+
+grant select on sqlcode.Version to [sqlcode-execute-role];
+grant select on sqlcode.Version to [sqlcode-deploy-role];
@@ -0,0 +1,25 @@
+-- Supports promoting a code schema to a stable alias, e.g. [code@current],
+-- where a synonym is created for each procedure and function of the code
+-- schema; see sqlcode.Promote. The alias schemas are created with
+-- sqlcode.CreateCodeSchema like other code schemas, so [sqlcode-deploy-role]
+-- only needs to be able to create synonyms in them.
+--
+-- sqlcode.Promotion is the history of promotions, used by sqlcode.Rollback
+-- to find the previous schema of an alias.
+
+create table sqlcode.Promotion (
+    PromotionID int identity not null primary key,
+    Alias varchar(50) not null,
+    SchemaSuffix varchar(50) not null,
+    PromotedAt datetime2 not null default sysutcdatetime(),
+    PromotedBy nvarchar(128) not null default original_login(),
+    RolledBackAt datetime2 null
+);
+
+create index Promotion_Alias on sqlcode.Promotion (Alias, PromotionID);
+
+go
+
+grant select, insert, update on sqlcode.Promotion to [sqlcode-deploy-role];
+grant select on sqlcode.Promotion to [sqlcode-execute-role];
+grant create synonym to [sqlcode-deploy-role];
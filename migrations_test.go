@@ -0,0 +1,91 @@
+package sqlcode
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlcodetest"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	require.NoError(t, err)
+	require.True(t, len(migrations) >= 4)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+		for _, b := range m.Batches {
+			assert.False(t, strings.HasPrefix(strings.TrimSpace(b), "go"), m.Name)
+		}
+	}
+	assert.Equal(t, "0001.sqlcode.sql", migrations[0].Name)
+	assert.True(t, strings.HasPrefix(strings.TrimSpace(migrations[0].Batches[1]), "create schema sqlcode"))
+
+	// create procedure has to start a batch; 0002 is split although the
+	// released file is not
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(migrations[1].Batches[0]), "drop procedure sqlcode.DropCodeSchema;"))
+	assert.True(t, strings.HasPrefix(migrations[1].Batches[1], "create procedure sqlcode.DropCodeSchema"))
+}
+
+func TestInstallOrUpgrade(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+
+	latest, err := LatestMigrationVersion()
+	require.NoError(t, err)
+	assert.Equal(t, latest, sqlcodetest.LibraryVersion, "update sqlcodetest.LibraryVersion")
+
+	fake.SetVersion(0)
+	require.NoError(t, InstallOrUpgrade(ctx, dbc))
+	assert.Equal(t, latest, fake.Version())
+	assert.Equal(t, 1, fake.Count("create schema sqlcode"))
+	assert.Equal(t, latest, fake.Count("sp_getapplock"))
+	assert.Equal(t, "", fake.LockMode("sqlcode.InstallOrUpgrade"))
+
+	// Nothing more to do
+	n := len(fake.Statements())
+	require.NoError(t, InstallOrUpgrade(ctx, dbc))
+	assert.Equal(t, n+1, len(fake.Statements()))
+
+	// Only the missing migrations are applied
+	fake.SetVersion(3)
+	require.NoError(t, InstallOrUpgrade(ctx, dbc))
+	assert.Equal(t, 1, fake.Count("create schema sqlcode"))
+	assert.Equal(t, 2, fake.Count("create procedure sqlcode.AssertEquals"))
+	assert.Equal(t, latest, fake.Version())
+}
+
+func TestLibraryVersionCheck(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+
+	fake.SetVersion(0)
+	d := fakeDeployable(t)
+	err := d.EnsureUploaded(ctx, dbc)
+	var versionErr LibraryVersionError
+	require.True(t, errors.As(err, &versionErr))
+	assert.Equal(t, LibraryVersionError{Installed: 0, Required: 1, Reason: "sqlcode"}, versionErr)
+	assert.Equal(t, 0, fake.Count("sqlcode.CreateCodeSchema"))
+	// also when uploading without EnsureUploaded
+	require.True(t, errors.As(d.Upload(ctx, dbc), &versionErr))
+	assert.Equal(t, 0, fake.Count("sqlcode.CreateCodeSchema"))
+
+	fake.SetVersion(2)
+	require.NoError(t, d.EnsureUploaded(ctx, dbc))
+
+	fs := fstest.MapFS{
+		"test.sql": &fstest.MapFile{Data: []byte(`--sqlcode:namespace other
+create procedure [other].Foo as select 1
+`)},
+	}
+	withNamespace, err := Include(Options{}, fs)
+	require.NoError(t, err)
+	assert.EqualError(t, withNamespace.EnsureUploaded(ctx, dbc),
+		"the sqlcode library installed in the database is version 2, but using namespaces other than [code] requires version 3; run `sqlcode install` or call sqlcode.InstallOrUpgrade to upgrade")
+}
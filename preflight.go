@@ -0,0 +1,124 @@
+package sqlcode
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// PreflightError lists the problems found by Deployable.Preflight
+type PreflightError struct {
+	Problems []string
+}
+
+func (e PreflightError) Error() string {
+	var msg strings.Builder
+	msg.WriteString("sqlcode preflight check failed:\n")
+	for _, p := range e.Problems {
+		msg.WriteString("\n- " + p)
+	}
+	return msg.String()
+}
+
+// Preflight checks that the connected user has what it takes to upload the
+// receiver: that the sqlcode migrations are installed, membership in
+// [sqlcode-deploy-role], permission to impersonate the sandbox user, and
+// that the uploading principal can create procedures, functions and types
+// and execute sqlcode.CreateCodeSchema. A PreflightError listing how to fix
+// each problem is returned if anything is missing.
+func (d Deployable) Preflight(ctx context.Context, dbc DB) error {
+	var problems []string
+
+	sandboxUser := d.SandboxUser()
+	var (
+		userName                           string
+		sqlcodeInstalled, deployRoleMember bool
+		sandboxExists, canImpersonate      bool
+	)
+	err := dbc.QueryRowContext(ctx, `
+select
+    user_name(),
+    cast(iif(schema_id('sqlcode') is null, 0, 1) as bit),
+    cast(iif(is_rolemember('sqlcode-deploy-role') = 1 or is_rolemember('db_owner') = 1, 1, 0) as bit),
+    cast(iif(database_principal_id(@sandbox) is null, 0, 1) as bit),
+    cast(isnull(has_perms_by_name(@sandbox, 'USER', 'IMPERSONATE'), 0) as bit)
+`, sql.Named("sandbox", sandboxUser)).Scan(&userName, &sqlcodeInstalled, &deployRoleMember, &sandboxExists, &canImpersonate)
+	if err != nil {
+		return err
+	}
+
+	if !sqlcodeInstalled {
+		return PreflightError{Problems: []string{
+			"the sqlcode library is not installed in the database; run `sqlcode install` or see sqlcode.InstallOrUpgrade",
+		}}
+	}
+	if !deployRoleMember {
+		problems = append(problems, fmt.Sprintf(
+			"user [%s] is not a member of [sqlcode-deploy-role]; run `alter role [sqlcode-deploy-role] add member [%s]`",
+			userName, userName))
+	}
+
+	if sandboxUser != "" {
+		if !sandboxExists {
+			problems = append(problems, fmt.Sprintf(
+				"the sandbox user [%s] does not exist; create it and add it to [sqlcode-deploy-role], or configure another sandbox user / skip impersonation",
+				sandboxUser))
+			return PreflightError{Problems: problems}
+		}
+		if !canImpersonate {
+			problems = append(problems, fmt.Sprintf(
+				"user [%s] can not impersonate [%s]; run `grant impersonate on user::[%s] to [sqlcode-deploy-role]`",
+				userName, sandboxUser, sandboxUser))
+			return PreflightError{Problems: problems}
+		}
+	}
+
+	// The rest is checked as the principal doing the upload
+	principal := sandboxUser
+	if principal == "" {
+		principal = userName
+	}
+	err = d.sandboxed(ctx, dbc, func(conn *sql.Conn) error {
+		checks := []struct {
+			permission, object, fix string
+		}{
+			{"CREATE PROCEDURE", "", "grant create procedure to [sqlcode-deploy-role]"},
+			{"CREATE FUNCTION", "", "grant create function to [sqlcode-deploy-role]"},
+			{"CREATE TYPE", "", "grant create type to [sqlcode-deploy-role]"},
+			{"EXECUTE", "sqlcode.CreateCodeSchema", "grant execute on sqlcode.CreateCodeSchema to [sqlcode-deploy-role]"},
+			{"EXECUTE", "sqlcode.DropCodeSchema", "grant execute on sqlcode.DropCodeSchema to [sqlcode-deploy-role]"},
+		}
+		for _, check := range checks {
+			var granted bool
+			var err error
+			if check.object == "" {
+				err = conn.QueryRowContext(ctx, `select cast(isnull(has_perms_by_name(db_name(), 'DATABASE', @permission), 0) as bit)`,
+					sql.Named("permission", check.permission)).Scan(&granted)
+			} else {
+				err = conn.QueryRowContext(ctx, `select cast(isnull(has_perms_by_name(@object, 'OBJECT', @permission), 0) as bit)`,
+					sql.Named("object", check.object),
+					sql.Named("permission", check.permission)).Scan(&granted)
+			}
+			if err != nil {
+				return err
+			}
+			if !granted {
+				what := check.permission
+				if check.object != "" {
+					what += " on " + check.object
+				}
+				problems = append(problems, fmt.Sprintf("[%s] lacks %s; run `%s`", principal, what, check.fix))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return PreflightError{Problems: problems}
+	}
+	return nil
+}
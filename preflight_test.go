@@ -0,0 +1,58 @@
+package sqlcode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlcodetest"
+)
+
+func TestPreflight(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	d := fakeDeployable(t)
+
+	principals := []string{"", "", "", "", ""}
+	fake.Respond("user_name()", principals, []interface{}{"app", true, true, true, true})
+	fake.Respond("'DATABASE', @permission", []string{""}, []interface{}{true})
+	fake.Respond("'OBJECT', @permission", []string{""}, []interface{}{true})
+	require.NoError(t, d.Preflight(ctx, dbc))
+	assert.Equal(t, 1, fake.Count("execute as user"))
+
+	fake.Respond("'OBJECT', @permission", []string{""}, []interface{}{false})
+	fake.Respond("user_name()", principals, []interface{}{"app", true, false, true, true})
+	assert.EqualError(t, d.Preflight(ctx, dbc), `sqlcode preflight check failed:
+
+- user [app] is not a member of [sqlcode-deploy-role]; run `+"`alter role [sqlcode-deploy-role] add member [app]`"+`
+- [sqlcode-deploy-sandbox-user] lacks EXECUTE on sqlcode.CreateCodeSchema; run `+"`grant execute on sqlcode.CreateCodeSchema to [sqlcode-deploy-role]`"+`
+- [sqlcode-deploy-sandbox-user] lacks EXECUTE on sqlcode.DropCodeSchema; run `+"`grant execute on sqlcode.DropCodeSchema to [sqlcode-deploy-role]`")
+
+	fake.Respond("user_name()", principals, []interface{}{"app", true, true, true, false})
+	assert.EqualError(t, d.Preflight(ctx, dbc), `sqlcode preflight check failed:
+
+- user [app] can not impersonate [sqlcode-deploy-sandbox-user]; run `+"`grant impersonate on user::[sqlcode-deploy-sandbox-user] to [sqlcode-deploy-role]`")
+
+	// Without impersonation, the permissions of the connected user are checked
+	skip := fakeDeployableWithOptions(t, Options{SkipImpersonation: true})
+	assert.Equal(t, "", skip.SandboxUser())
+	fake.Respond("'DATABASE', @permission", []string{""}, []interface{}{false})
+	fake.Respond("'OBJECT', @permission", []string{""}, []interface{}{true})
+	err := skip.Preflight(ctx, dbc)
+	assert.Contains(t, err.Error(), "[app] lacks CREATE PROCEDURE; run `grant create procedure to [sqlcode-deploy-role]`")
+	assert.Equal(t, 2, fake.Count("execute as user"))
+
+	// The sandbox user is used for uploads
+	custom := fakeDeployableWithOptions(t, Options{SandboxUser: "it's-me"})
+	require.NoError(t, custom.EnsureUploaded(ctx, dbc))
+	statements := fake.Statements()
+	var impersonated []interface{}
+	for _, s := range statements {
+		if s.Args["username"] != nil {
+			impersonated = append(impersonated, s.Args["username"])
+		}
+	}
+	assert.Equal(t, "it's-me", impersonated[len(impersonated)-1])
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/vippsas/sqlcode/sqlparser"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -33,7 +34,13 @@ func SchemaSuffixFromHash(doc sqlparser.Document) string {
 }
 
 func SchemaName(suffix string) string {
-	return "code@" + suffix
+	return NamespaceSchemaName("code", suffix)
+}
+
+// NamespaceSchemaName is SchemaName for the virtual schema of a namespace
+// declared with the `--sqlcode:namespace` pragma
+func NamespaceSchemaName(namespace, suffix string) string {
+	return namespace + "@" + suffix
 }
 
 type lineNumberCorrection struct {
@@ -44,6 +51,13 @@ type Batch struct {
 	StartPos sqlparser.Pos
 	Lines    string
 
+	// The create statement the batch was made from
+	Namespace  string
+	QuotedName string
+
+	// SourceMap maps positions in Lines back to the source files, see InputPos()
+	SourceMap SourceMap
+
 	// lineNumberCorrections contains data that helps us map from errors in the `Lines`
 	// SQL result and back to the original source file (pointed at by StartPos).
 	// See comments in RelativeLineNumberInInput()
@@ -81,6 +95,16 @@ func (b Batch) RelativeLineNumberInInput(outputline int) int {
 	return outputline - totalExtraLines
 }
 
+// InputPos maps a (1-based) line and column in Lines back to the source file;
+// e.g. to find the origin of an error raised when executing the batch
+func (b Batch) InputPos(line, col int) sqlparser.Pos {
+	pos, ok := b.SourceMap.InputPos(line, col)
+	if !ok {
+		return b.StartPos
+	}
+	return pos
+}
+
 type PreprocessedFile struct {
 	Batches []Batch
 }
@@ -94,14 +118,48 @@ func (p PreprocessorError) Error() string {
 	return fmt.Sprintf("%s:%d:%d: %s", p.Pos.File, p.Pos.Line, p.Pos.Col, p.Message)
 }
 
-var codeSchemaRegexp = regexp.MustCompile(`(?i)\[code\]`)
+// schemaReplacements maps each virtual schema (`[code]`, `[billing]`) to the
+// real schema it is uploaded to (`[code@suffix]`, `[billing@suffix]`)
+func schemaReplacements(namespaces []string, schemasuffix string) map[string]string {
+	result := make(map[string]string)
+	for _, ns := range namespaces {
+		result["["+ns+"]"] = "[" + NamespaceSchemaName(ns, schemasuffix) + "]"
+	}
+	return result
+}
+
+// createSchemas is `schemas` without the namespaces that are not declared in
+// the file of the create statement; like in the parser, a `[billing]` in a
+// file without the `--sqlcode:namespace billing` pragma is left alone
+func createSchemas(schemas map[string]string, namespaces []string, c sqlparser.Create) map[string]string {
+	var result map[string]string
+	for _, ns := range namespaces {
+		virtual := "[" + ns + "]"
+		if _, ok := schemas[virtual]; !ok || c.IsVirtualSchema(virtual) {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string, len(schemas))
+			for k, v := range schemas {
+				result[k] = v
+			}
+		}
+		delete(result, virtual)
+	}
+	if result == nil {
+		return schemas
+	}
+	return result
+}
 
-func sqlcodeTransformCreate(declares map[string]string, c sqlparser.Create, quotedTargetSchema string) (result Batch, err error) {
-	var w strings.Builder
+func sqlcodeTransformCreate(declares map[string]string, c sqlparser.Create, schemas map[string]string) (result Batch, err error) {
+	w := newSourceMapWriter()
 
 	if len(c.Body) > 0 {
 		result.StartPos = c.Body[0].Start
 	}
+	result.Namespace = c.Namespace
+	result.QuotedName = c.QuotedName.Value
 
 	// Since the parser doesn't understand much
 	// this is currently very simple to do, just transform a
@@ -109,12 +167,13 @@ func sqlcodeTransformCreate(declares map[string]string, c sqlparser.Create, quot
 
 	// A @Enum replacement can lead to line numbers changing due to \n present in the literal.
 	// For this reason we need to make a mapping between source line numbers and result
-	// line numbers
+	// line numbers. Replacements also shift columns, which is tracked in the SourceMap
+	// built by the writer.
 	for _, u := range c.Body {
 		token := u.RawValue
 		switch {
-		case u.Type == sqlparser.QuotedIdentifierToken && u.RawValue == "[code]":
-			token = quotedTargetSchema
+		case u.Type == sqlparser.QuotedIdentifierToken && schemas[u.RawValue] != "":
+			token = schemas[u.RawValue]
 		case u.Type == sqlparser.VariableIdentifierToken && sqlparser.IsSqlcodeConstVariable(u.RawValue):
 			constLiteral, ok := declares[u.RawValue]
 			if !ok {
@@ -129,16 +188,21 @@ func sqlcodeTransformCreate(declares map[string]string, c sqlparser.Create, quot
 			}
 		}
 
-		if _, err = w.WriteString(token); err != nil {
-			return
-		}
+		w.write(token, u.Start, token != u.RawValue)
 	}
 
 	result.Lines = w.String()
+	result.SourceMap = w.sourceMap
 	return
 }
 
 func Preprocess(doc sqlparser.Document, schemasuffix string) (PreprocessedFile, error) {
+	return preprocess(doc, schemasuffix, schemaReplacements(doc.Namespaces(), schemasuffix))
+}
+
+// preprocess does the work of Preprocess; `schemas` maps from the virtual
+// schemas to replace to the real schema names, see schemaReplacements()
+func preprocess(doc sqlparser.Document, schemasuffix string, schemas map[string]string) (PreprocessedFile, error) {
 	var result PreprocessedFile
 
 	if strings.Contains(schemasuffix, "]") {
@@ -150,11 +214,12 @@ func Preprocess(doc sqlparser.Document, schemasuffix string) (PreprocessedFile,
 		declares[dec.VariableName] = dec.Literal.RawValue
 	}
 
+	namespaces := doc.Namespaces()
 	for _, create := range doc.Creates {
 		if len(create.Body) == 0 {
 			continue
 		}
-		batch, err := sqlcodeTransformCreate(declares, create, "[code@"+schemasuffix+"]")
+		batch, err := sqlcodeTransformCreate(declares, create, createSchemas(schemas, namespaces, create))
 		if err != nil {
 			return result, err
 		}
@@ -164,6 +229,49 @@ func Preprocess(doc sqlparser.Document, schemasuffix string) (PreprocessedFile,
 	return result, nil
 }
 
-func preprocessString(schemasuffix string, sql string) string {
-	return codeSchemaRegexp.ReplaceAllString(sql, "["+SchemaName(schemasuffix)+"]")
+// preprocessString does the replacements of `schemas` (see schemaReplacements())
+// in sql; unlike in Preprocess, matching is case-insensitive
+func preprocessString(schemas map[string]string, sql string) string {
+	return newSchemaPatcher(schemas).patch(sql)
+}
+
+// schemaPatcher is preprocessString with the regexp compiled once; see
+// Deployable.Patch
+type schemaPatcher struct {
+	re      *regexp.Regexp
+	schemas map[string]string // lower-case virtual schema -> real schema
+}
+
+func newSchemaPatcher(schemas map[string]string) *schemaPatcher {
+	var alternatives []string
+	lowerSchemas := make(map[string]string)
+	for virtual, real := range schemas {
+		alternatives = append(alternatives, regexp.QuoteMeta(virtual))
+		lowerSchemas[strings.ToLower(virtual)] = real
+	}
+	sort.Strings(alternatives)
+	return &schemaPatcher{
+		re:      regexp.MustCompile(`(?i)` + strings.Join(alternatives, "|")),
+		schemas: lowerSchemas,
+	}
+}
+
+func (p *schemaPatcher) patch(sql string) string {
+	return p.re.ReplaceAllStringFunc(sql, func(match string) string {
+		return p.schemas[strings.ToLower(match)]
+	})
+}
+
+func schemaSuffixWithImports(suffix string, imports []Deployable) string {
+	var lines []string
+	for _, imp := range imports {
+		lines = append(lines, fmt.Sprintf("import [%s] = %s\n", imp.Name, imp.SchemaSuffix))
+	}
+	sort.Strings(lines)
+	hasher := sha256.New()
+	hasher.Write([]byte(suffix + "\n"))
+	for _, line := range lines {
+		hasher.Write([]byte(line))
+	}
+	return hex.EncodeToString(hasher.Sum(nil)[:6])
 }
@@ -63,3 +63,87 @@ func TestLineNumberInInput(t *testing.T) {
 	}
 	assert.Equal(t, expectedInputLineNumbers, inputlines[1:])
 }
+
+func TestPreprocessNamespaces(t *testing.T) {
+	doc := sqlparser.ParseString("test.sql", `--sqlcode:namespace billing
+create function [billing].Rate() returns int as begin return 1 end
+go
+create procedure [code].Foo as select [billing].Rate()
+`)
+	require.Empty(t, doc.Errors)
+
+	result, err := Preprocess(doc, "abc")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(result.Batches))
+	assert.Equal(t, "create function [billing@abc].Rate() returns int as begin return 1 end\n", result.Batches[0].Lines)
+	assert.Equal(t, "create procedure [code@abc].Foo as select [billing@abc].Rate()\n", result.Batches[1].Lines)
+
+	d := Deployable{SchemaSuffix: "abc", CodeBase: doc}
+	assert.Equal(t, "select [code@abc].Foo, [billing@abc].Rate(), [Billing2].x", d.Patch("select [CODE].Foo, [Billing].Rate(), [Billing2].x"))
+}
+
+func TestPreprocessNamespaceNotDeclaredInFile(t *testing.T) {
+	doc := sqlparser.ParseString("billing.sql", `--sqlcode:namespace billing
+create function [billing].Rate() returns int as begin return 1 end
+`)
+	doc.Include(sqlparser.ParseString("other.sql", `create procedure [code].Foo as select [billing].Rate()
+`))
+	require.Empty(t, doc.Errors)
+	assert.Empty(t, doc.Creates[1].DependsOn)
+
+	// Not a reference to the namespace in other.sql, so not rewritten either
+	result, err := Preprocess(doc, "abc")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(result.Batches))
+	assert.Equal(t, "create procedure [code@abc].Foo as select [billing].Rate()\n", result.Batches[1].Lines)
+}
+
+func TestPatchCachesReplacements(t *testing.T) {
+	d := fakeDeployable(t).WithSchemaSuffix("abc")
+	assert.Equal(t, "exec [code@abc].Foo", d.Patch("exec [code].Foo"))
+	patcher := d.patcher.value
+	assert.Equal(t, "exec [code@abc].Bar", d.Patch("exec [code].Bar"))
+	assert.Same(t, patcher, d.patcher.value)
+
+	// shared by copies, but rebuilt when the suffix is changed
+	c := d
+	c.SchemaSuffix = "def"
+	assert.Equal(t, "exec [code@def].Foo", c.Patch("exec [code].Foo"))
+	assert.Equal(t, "exec [code@abc].Foo", d.Patch("exec [code].Foo"))
+}
+
+func TestSourceMap(t *testing.T) {
+	doc := sqlparser.ParseString("test.sql", `declare @EnumMulti varchar(max) = 'a
+b', @EnumOne int = 1;
+go
+
+  create procedure [code].Foo as
+    select @EnumOne, @EnumMulti, [code].Bar() -- comment
+    select x from y
+`)
+	require.Empty(t, doc.Errors)
+	result, err := Preprocess(doc, "abc")
+	require.NoError(t, err)
+	b := result.Batches[0]
+	assert.Equal(t, `create procedure [code@abc].Foo as
+    select 1/*=@EnumOne*/, 'a
+b'/*=@EnumMulti*/, [code@abc].Bar() -- comment
+    select x from y
+`, b.Lines)
+
+	pos := func(line, col int) sqlparser.Pos {
+		return sqlparser.Pos{File: "test.sql", Line: line, Col: col}
+	}
+	// `create` does not start in column 1 in the input
+	assert.Equal(t, pos(5, 3), b.InputPos(1, 1))
+	// `Foo`; shifted by the suffix on the same line
+	assert.Equal(t, pos(5, 27), b.InputPos(1, 29))
+	// within replaced constants
+	assert.Equal(t, pos(6, 12), b.InputPos(2, 14))
+	assert.Equal(t, pos(6, 22), b.InputPos(3, 1))
+	// `[code@abc]` after the multi-line constant, and the comment after it
+	assert.Equal(t, pos(6, 34), b.InputPos(3, 20))
+	assert.Equal(t, pos(6, 47), b.InputPos(3, 37))
+	// unaffected line
+	assert.Equal(t, pos(7, 5), b.InputPos(4, 5))
+}
@@ -0,0 +1,235 @@
+package sqlcode
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// promotionLibraryVersion is the migration that added sqlcode.Promotion
+const promotionLibraryVersion = 6
+
+// Promotion is an entry in the history of an alias; see Promote
+type Promotion struct {
+	Alias        string
+	SchemaSuffix string
+	PromotedAt   time.Time
+	PromotedBy   string
+	RolledBack   bool
+}
+
+// Promote makes the alias point to the code uploaded with the receiver's
+// schema suffix; see the package-level Promote
+func (d Deployable) Promote(ctx context.Context, dbc DB, alias string) error {
+	return Promote(ctx, dbc, d.SchemaSuffix, alias)
+}
+
+// Promote makes a stable alias, e.g. "current", for the code schemas with
+// the given suffix, for tools and services that do not know the suffix:
+// In `[<namespace>@<alias>]`, a synonym is created for each procedure,
+// function and view in `[<namespace>@<schemasuffix>]`, replacing the
+// synonyms of any previous promotion. This is done in a single transaction,
+// and recorded in the sqlcode.Promotion table so that Rollback can go back
+// to the previous suffix. Types can not be promoted, as SQL Server has no
+// synonyms for them.
+func Promote(ctx context.Context, dbc DB, schemasuffix, alias string) error {
+	return inPromotionTx(ctx, dbc, alias, func(tx *sql.Tx) error {
+		if err := promote(ctx, tx, schemasuffix, alias); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `insert into sqlcode.Promotion (Alias, SchemaSuffix) values (@alias, @schemasuffix)`,
+			sql.Named("alias", alias),
+			sql.Named("schemasuffix", schemasuffix),
+		)
+		return err
+	})
+}
+
+// Rollback makes the alias point to the schema suffix it was promoted to
+// before the current one, and returns that suffix. The current promotion
+// is marked as rolled back in the history, so that calling Rollback again
+// goes further back.
+func Rollback(ctx context.Context, dbc DB, alias string) (schemasuffix string, err error) {
+	err = inPromotionTx(ctx, dbc, alias, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+select top(2) PromotionID, SchemaSuffix
+from sqlcode.Promotion
+where Alias = @alias and RolledBackAt is null
+order by PromotionID desc
+`, sql.Named("alias", alias))
+		if err != nil {
+			return err
+		}
+		var ids []int
+		var suffixes []string
+		for rows.Next() {
+			var id int
+			var suffix string
+			if err := rows.Scan(&id, &suffix); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			suffixes = append(suffixes, suffix)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if len(ids) < 2 {
+			return fmt.Errorf("no earlier promotion of [%s] to roll back to", alias)
+		}
+
+		if _, err := tx.ExecContext(ctx, `update sqlcode.Promotion set RolledBackAt = sysutcdatetime() where PromotionID = @id`,
+			sql.Named("id", ids[0])); err != nil {
+			return err
+		}
+		schemasuffix = suffixes[1]
+		return promote(ctx, tx, schemasuffix, alias)
+	})
+	if err != nil {
+		return "", err
+	}
+	return schemasuffix, nil
+}
+
+// Promotions returns the history of promotions of the alias, latest first
+func Promotions(ctx context.Context, dbc DB, alias string) (result []Promotion, err error) {
+	rows, err := dbc.QueryContext(ctx, `
+select Alias, SchemaSuffix, PromotedAt, PromotedBy, cast(iif(RolledBackAt is null, 0, 1) as bit)
+from sqlcode.Promotion
+where Alias = @alias
+order by PromotionID desc
+`, sql.Named("alias", alias))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p Promotion
+		if err := rows.Scan(&p.Alias, &p.SchemaSuffix, &p.PromotedAt, &p.PromotedBy, &p.RolledBack); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+// inPromotionTx runs f in a transaction holding a lock on the alias
+func inPromotionTx(ctx context.Context, dbc DB, alias string, f func(tx *sql.Tx) error) error {
+	if alias == "" {
+		return errors.New("empty alias")
+	}
+	installed, err := InstalledVersion(ctx, dbc)
+	if err != nil {
+		return err
+	}
+	if installed < promotionLibraryVersion {
+		return LibraryVersionError{Installed: installed, Required: promotionLibraryVersion, Reason: "promotion"}
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := lockInTx(ctx, tx, "sqlcode.Promote/"+alias); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+type schemaObject struct {
+	namespace, name string
+}
+
+// promote replaces the synonyms in the alias schemas
+func promote(ctx context.Context, tx *sql.Tx, schemasuffix, alias string) error {
+	if schemasuffix == alias {
+		return fmt.Errorf("can not promote [%s] to itself", alias)
+	}
+
+	// Only code schemas (i.e., owned by [sqlcode-user-with-no-permissions]) are
+	// considered; the namespace is the part of the name before '@'
+	queryObjects := func(suffix string, synonyms bool) (result []schemaObject, err error) {
+		rows, err := tx.QueryContext(ctx, `
+select left(s.name, len(s.name) - len(@suffix) - 1), o.name
+from sys.objects o
+join sys.schemas s on s.schema_id = o.schema_id
+where s.principal_id = user_id('sqlcode-user-with-no-permissions')
+    and right(s.name, len(@suffix) + 1) = concat('@', @suffix)
+    and o.parent_object_id = 0
+    and iif(o.type = 'SN', 1, 0) = @synonyms
+    and o.type in ('P', 'PC', 'FN', 'IF', 'TF', 'FS', 'FT', 'V', 'SN')
+order by s.name, o.name
+`, sql.Named("suffix", suffix), sql.Named("synonyms", synonyms))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var obj schemaObject
+			if err := rows.Scan(&obj.namespace, &obj.name); err != nil {
+				return nil, err
+			}
+			result = append(result, obj)
+		}
+		return result, rows.Err()
+	}
+
+	objects, err := queryObjects(schemasuffix, false)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("no code found in schemas with suffix %s", schemasuffix)
+	}
+	// the alias schemas must only contain synonyms, so that uploaded code
+	// is never replaced by a promotion
+	aliasCode, err := queryObjects(alias, false)
+	if err != nil {
+		return err
+	}
+	if len(aliasCode) > 0 {
+		return fmt.Errorf("[%s] contains code, and can not be used as an alias",
+			NamespaceSchemaName(aliasCode[0].namespace, alias))
+	}
+	oldSynonyms, err := queryObjects(alias, true)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range oldSynonyms {
+		if _, err := tx.ExecContext(ctx, "drop synonym "+quoteName(NamespaceSchemaName(obj.namespace, alias))+"."+quoteName(obj.name)); err != nil {
+			return err
+		}
+	}
+	created := make(map[string]bool)
+	for _, obj := range objects {
+		if !created[obj.namespace] {
+			var schemaID int
+			err := tx.QueryRowContext(ctx, `select isnull(schema_id(@p1), 0)`, NamespaceSchemaName(obj.namespace, alias)).Scan(&schemaID)
+			if err != nil {
+				return err
+			}
+			if schemaID == 0 {
+				if _, err := tx.ExecContext(ctx, `sqlcode.CreateCodeSchema`, namespaceArgs(alias, obj.namespace)...); err != nil {
+					return err
+				}
+			}
+			created[obj.namespace] = true
+		}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("create synonym %s.%s for %s.%s",
+			quoteName(NamespaceSchemaName(obj.namespace, alias)), quoteName(obj.name),
+			quoteName(NamespaceSchemaName(obj.namespace, schemasuffix)), quoteName(obj.name)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
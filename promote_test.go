@@ -0,0 +1,60 @@
+package sqlcode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlcodetest"
+)
+
+func TestPromote(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+
+	// The objects in the code schemas with each suffix
+	code := map[string][][]interface{}{
+		"abc": {{"billing", "Charge"}, {"code", "Foo"}},
+		"def": {{"code", "Foo"}},
+	}
+	synonyms := map[string][][]interface{}{
+		"current": {{"code", "Foo"}},
+	}
+	fake.RespondFunc("= @synonyms", func(args map[string]interface{}) ([]string, [][]interface{}) {
+		if args["synonyms"] == true {
+			return []string{"", ""}, synonyms[args["suffix"].(string)]
+		}
+		return []string{"", ""}, code[args["suffix"].(string)]
+	})
+
+	require.NoError(t, Promote(ctx, dbc, "abc", "current"))
+	assert.Equal(t, 1, fake.Count("drop synonym [code@current].[Foo]"))
+	assert.Equal(t, 1, fake.Count("create synonym [billing@current].[Charge] for [billing@abc].[Charge]"))
+	assert.Equal(t, 1, fake.Count("create synonym [code@current].[Foo] for [code@abc].[Foo]"))
+	assert.ElementsMatch(t, []string{"billing@current", "code@current"}, fake.Schemas())
+	assert.Equal(t, 1, fake.Count("insert into sqlcode.Promotion"))
+	assert.Equal(t, "", fake.LockMode("sqlcode.Promote/current"))
+
+	// Rolling back goes to the promotion before the current one
+	fake.Respond("top(2) PromotionID", []string{"", ""}, []interface{}{int64(2), "def"}, []interface{}{int64(1), "abc"})
+	suffix, err := Rollback(ctx, dbc, "current")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", suffix)
+	assert.Equal(t, 1, fake.Count("update sqlcode.Promotion set RolledBackAt"))
+	assert.Equal(t, 2, fake.Count("create synonym [code@current].[Foo] for [code@abc].[Foo]"))
+	assert.Equal(t, 1, fake.Count("insert into sqlcode.Promotion"))
+
+	fake.Respond("top(2) PromotionID", []string{"", ""}, []interface{}{int64(1), "abc"})
+	_, err = Rollback(ctx, dbc, "current")
+	assert.EqualError(t, err, "no earlier promotion of [current] to roll back to")
+
+	// Aliases can not replace uploaded code
+	assert.EqualError(t, Promote(ctx, dbc, "abc", "def"), "[code@def] contains code, and can not be used as an alias")
+	assert.EqualError(t, Promote(ctx, dbc, "xyz", "current"), "no code found in schemas with suffix xyz")
+
+	fake.SetVersion(5)
+	assert.EqualError(t, Promote(ctx, dbc, "abc", "current"),
+		"the sqlcode library installed in the database is version 5, but promotion requires version 6; run `sqlcode install` or call sqlcode.InstallOrUpgrade to upgrade")
+}
@@ -0,0 +1,127 @@
+package sqlcode
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+// describedColumn is a column as described by sp_describe_first_result_set
+type describedColumn struct {
+	Name           string
+	SystemTypeName string
+	Nullable       bool
+}
+
+// describeFirstResultSet runs sp_describe_first_result_set on `exec name`
+func describeFirstResultSet(ctx context.Context, q checkQuerier, name string) (result []describedColumn, err error) {
+	rows, err := q.QueryContext(ctx, `sp_describe_first_result_set`, sql.Named("tsql", "exec "+name))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new(interface{})
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		var col describedColumn
+		for i, column := range columns {
+			v := *(values[i].(*interface{}))
+			switch column {
+			case "name":
+				col.Name = asString(v)
+			case "system_type_name":
+				col.SystemTypeName = asString(v)
+			case "is_nullable":
+				col.Nullable, _ = v.(bool)
+			}
+		}
+		result = append(result, col)
+	}
+	return result, rows.Err()
+}
+
+func asString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// checkResultSet runs sp_describe_first_result_set on the procedure, and
+// compares the result to the declared result set, if any
+func (d Deployable) checkResultSet(ctx context.Context, q checkQuerier, c sqlparser.Create, name string, report func(sqlparser.Create, sqlparser.Pos, string)) error {
+	declared, _ := c.ResultSet() // validated by Include
+	described, err := describeFirstResultSet(ctx, q, name)
+	var sqlerr mssql.Error
+	if errors.As(err, &sqlerr) && sqlerr.Number >= 11500 && sqlerr.Number < 11600 {
+		// sp_describe_first_result_set can not tell the result
+		if declared != nil {
+			report(c, declared.Pos, "the result set can not be verified: "+sqlerr.Message)
+		}
+		return nil
+	}
+	if err != nil {
+		if !d.reportCheckError(c, err, report) {
+			return err
+		}
+		return nil
+	}
+	if declared == nil {
+		return nil
+	}
+	for _, problem := range compareResultSet(declared.Columns, described) {
+		report(c, declared.Pos, problem)
+	}
+	return nil
+}
+
+// compareResultSet lists the differences between the declared and the
+// described columns
+func compareResultSet(declared []sqlparser.ResultSetColumn, described []describedColumn) (problems []string) {
+	for i := 0; i < len(declared) || i < len(described); i++ {
+		switch {
+		case i >= len(described):
+			problems = append(problems, fmt.Sprintf("resultset: column %d, %s, is declared but not returned", i+1, declared[i].Name))
+		case i >= len(declared):
+			problems = append(problems, fmt.Sprintf("resultset: column %d, %s %s, is returned but not declared", i+1, described[i].Name, described[i].SystemTypeName))
+		case !strings.EqualFold(declared[i].Name, described[i].Name):
+			problems = append(problems, fmt.Sprintf("resultset: column %d is declared as %s, but returned as %s", i+1, declared[i].Name, described[i].Name))
+		default:
+			if !declared[i].SameType(described[i].SystemTypeName) {
+				problems = append(problems, fmt.Sprintf("resultset: column %s is declared as %s, but returned as %s", declared[i].Name, declared[i].Type, described[i].SystemTypeName))
+			}
+			if declared[i].Nullable != described[i].Nullable {
+				problems = append(problems, fmt.Sprintf("resultset: column %s is declared with nullable: %t, but returned with nullable: %t", declared[i].Name, declared[i].Nullable, described[i].Nullable))
+			}
+		}
+	}
+	return
+}
+
+// checkResultSetDeclarations validates the result sets declared in docstrings
+func checkResultSetDeclarations(doc sqlparser.Document) (result []sqlparser.Error) {
+	for _, c := range doc.Creates {
+		var parseErr sqlparser.Error
+		if _, err := c.ResultSet(); errors.As(err, &parseErr) {
+			result = append(result, parseErr)
+		}
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package sqlcode
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlcodetest"
+)
+
+var resultSetFS = fstest.MapFS{
+	"orders.sql": &fstest.MapFile{Data: []byte(`--! resultset:
+--!   - {name: OrderID, type: bigint}
+--!   - {name: Comment, type: nvarchar(200), nullable: true}
+--!   - {name: created_at, type: datetime2(7)}
+create procedure [code].ListOrders as
+select OrderID, Comment, CreatedAt from dbo.Orders
+go
+create procedure [code].Other as select 1
+`)},
+}
+
+func TestUploadVerifiesResultSet(t *testing.T) {
+	ctx := context.Background()
+	d, err := Include(Options{}, resultSetFS)
+	require.NoError(t, err)
+
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	columns := []string{"is_hidden", "column_ordinal", "name", "is_nullable", "system_type_id", "system_type_name"}
+	fake.Respond("sp_describe_first_result_set", columns,
+		[]interface{}{false, int64(1), "OrderID", false, int64(127), "bigint"},
+		[]interface{}{false, int64(2), "Comment", false, int64(231), "nvarchar(100)"},
+		[]interface{}{false, int64(3), "CreatedAt", false, int64(42), "datetime2(7)"},
+		[]interface{}{false, int64(4), "Extra", true, int64(56), "int"},
+	)
+
+	err = d.EnsureUploaded(ctx, dbc)
+	assert.EqualError(t, err, `sqlcode check failed:
+
+orders.sql:1:1: [ListOrders]: resultset: column Comment is declared as nvarchar(200), but returned as nvarchar(100)
+orders.sql:1:1: [ListOrders]: resultset: column Comment is declared with nullable: true, but returned with nullable: false
+orders.sql:1:1: [ListOrders]: resultset: column 3 is declared as created_at, but returned as CreatedAt
+orders.sql:1:1: [ListOrders]: resultset: column 4, Extra int, is returned but not declared`)
+	assert.Empty(t, fake.Schemas())
+	// only procedures with a declared result set are described
+	assert.Equal(t, 1, fake.Count("sp_describe_first_result_set"))
+
+	fake.Respond("sp_describe_first_result_set", columns,
+		[]interface{}{false, int64(1), "OrderID", false, int64(127), "bigint"},
+		[]interface{}{false, int64(2), "Comment", true, int64(231), "nvarchar(200)"},
+		[]interface{}{false, int64(3), "created_at", false, int64(42), "datetime2(7)"},
+	)
+	assert.NoError(t, d.EnsureUploaded(ctx, dbc))
+	assert.Len(t, fake.Schemas(), 1)
+}
+
+func TestIncludeInvalidResultSet(t *testing.T) {
+	_, err := Include(Options{}, fstest.MapFS{
+		"bad.sql": &fstest.MapFile{Data: []byte(`--! resultset: [{name: x}]
+create procedure [code].Bad as select 1 as x
+`)},
+	})
+	assert.EqualError(t, err, "sqlcode syntax error:\n\nbad.sql:1:1: resultset: column x has no type\n")
+}
+
+func TestGoResultSetStructs(t *testing.T) {
+	d, err := Include(Options{}, resultSetFS)
+	require.NoError(t, err)
+	src, err := d.GoResultSetStructs("orders")
+	require.NoError(t, err)
+	assert.Equal(t, "// Code generated by sqlcode; DO NOT EDIT.\n"+`
+package orders
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ListOrdersRow is a row of the result set of [ListOrders]
+type ListOrdersRow struct {
+	OrderID   int64            `+"`db:\"OrderID\"`"+`
+	Comment   sql.Null[string] `+"`db:\"Comment\"`"+`
+	CreatedAt time.Time        `+"`db:\"created_at\"`"+`
+}
+`, string(src))
+}
@@ -0,0 +1,194 @@
+package sqlcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+// Route configures a version of the SQL code in a Router
+type Route struct {
+	// Name identifies the route in the Router (e.g. "current", "candidate");
+	// the default is the schema suffix of Deployable
+	Name       string
+	Deployable Deployable
+
+	// Weight is the relative share of calls this route gets, of those
+	// not matched by the Predicate of any route
+	Weight int
+
+	// Predicate, if set, sends all calls with keys it is true for to this
+	// route (e.g. a set of tenants); the first matching route wins
+	Predicate func(key string) bool
+}
+
+// Version is a Route in a Router, handed out by Router.Pick
+type Version struct {
+	route   Route
+	weight  atomic.Int64
+	calls   atomic.Int64
+	patched sync.Map // inline SQL -> patched SQL
+}
+
+func (v *Version) Name() string {
+	return v.route.Name
+}
+
+func (v *Version) SchemaSuffix() string {
+	return v.route.Deployable.SchemaSuffix
+}
+
+func (v *Version) Deployable() *Deployable {
+	return &v.route.Deployable
+}
+
+// Patch is Deployable.Patch, but each distinct sql is only rewritten once,
+// so it is meant for the inline SQL in the source code and not for SQL
+// built dynamically
+func (v *Version) Patch(sql string) string {
+	if patched, ok := v.patched.Load(sql); ok {
+		return patched.(string)
+	}
+	patched := v.route.Deployable.Patch(sql)
+	v.patched.Store(sql, patched)
+	return patched
+}
+
+// Router hands out one of several versions of the SQL code per call;
+// e.g. to shift batch jobs gradually from the current to a candidate version
+// during a rollout, with both uploaded:
+//
+//	router, err := sqlcode.NewRouter(
+//		sqlcode.Route{Name: "current", Deployable: current, Weight: 90},
+//		sqlcode.Route{Name: "candidate", Deployable: candidate, Weight: 10},
+//	)
+//	...
+//	v := router.Pick(tenantID)
+//	_, err = dbc.ExecContext(ctx, v.Patch(`[code].DoBatch`), ...)
+//
+// Calls are routed by a hash of the key, so that the same key consistently
+// goes to the same version as long as the weights stay the same. A Router
+// is safe for concurrent use.
+type Router struct {
+	versions []*Version
+}
+
+// RouteMetrics is what Router.Metrics reports for each route
+type RouteMetrics struct {
+	Name         string
+	SchemaSuffix string
+	Weight       int
+	Calls        int64 // the number of times the route was picked
+}
+
+// NewRouter makes a Router; the route names must be unique, and
+// weights can not be negative
+func NewRouter(routes ...Route) (*Router, error) {
+	if len(routes) == 0 {
+		return nil, errors.New("NewRouter: no routes")
+	}
+	r := &Router{}
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		if route.Name == "" {
+			route.Name = route.Deployable.SchemaSuffix
+		}
+		if seen[route.Name] {
+			return nil, fmt.Errorf("NewRouter: duplicate route %s", route.Name)
+		}
+		seen[route.Name] = true
+		if route.Weight < 0 {
+			return nil, fmt.Errorf("NewRouter: negative weight for route %s", route.Name)
+		}
+		v := &Version{route: route}
+		v.weight.Store(int64(route.Weight))
+		r.versions = append(r.versions, v)
+	}
+	return r, nil
+}
+
+// SetWeight changes the weight of a route
+func (r *Router) SetWeight(name string, weight int) error {
+	if weight < 0 {
+		return fmt.Errorf("negative weight for route %s", name)
+	}
+	for _, v := range r.versions {
+		if v.route.Name == name {
+			v.weight.Store(int64(weight))
+			return nil
+		}
+	}
+	return fmt.Errorf("no route named %s", name)
+}
+
+// Pick returns the version to use for a call. The key (e.g. a tenant ID) is
+// passed to the predicates, and hashed to pick by weight; calls with an empty
+// key are spread at random. If all weights are 0, the first route is used.
+func (r *Router) Pick(key string) *Version {
+	v := r.pick(key)
+	v.calls.Add(1)
+	return v
+}
+
+func (r *Router) pick(key string) *Version {
+	for _, v := range r.versions {
+		if v.route.Predicate != nil && v.route.Predicate(key) {
+			return v
+		}
+	}
+
+	var total int64
+	weights := make([]int64, len(r.versions))
+	for i, v := range r.versions {
+		weights[i] = v.weight.Load()
+		total += weights[i]
+	}
+	if total == 0 {
+		return r.versions[0]
+	}
+
+	var n uint64
+	if key == "" {
+		n = rand.Uint64()
+	} else {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		n = h.Sum64()
+	}
+	point := int64(n % uint64(total))
+	for i, v := range r.versions {
+		if point < weights[i] {
+			return v
+		}
+		point -= weights[i]
+	}
+	panic("not reached")
+}
+
+// Metrics returns the weight and number of calls of each route, in the
+// order they were given to NewRouter
+func (r *Router) Metrics() (result []RouteMetrics) {
+	for _, v := range r.versions {
+		result = append(result, RouteMetrics{
+			Name:         v.route.Name,
+			SchemaSuffix: v.SchemaSuffix(),
+			Weight:       int(v.weight.Load()),
+			Calls:        v.calls.Load(),
+		})
+	}
+	return
+}
+
+// EnsureUploaded calls EnsureUploaded for the Deployable of each route
+func (r *Router) EnsureUploaded(ctx context.Context, dbc DB) error {
+	for _, v := range r.versions {
+		if err := v.route.Deployable.EnsureUploaded(ctx, dbc); err != nil {
+			return fmt.Errorf("route %s: %w", v.route.Name, err)
+		}
+	}
+	return nil
+}
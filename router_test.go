@@ -0,0 +1,57 @@
+package sqlcode
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	current := fakeDeployable(t).WithSchemaSuffix("current1")
+	candidate := fakeDeployable(t).WithSchemaSuffix("candidate2")
+
+	router, err := NewRouter(
+		Route{Name: "current", Deployable: current, Weight: 90},
+		Route{Name: "candidate", Deployable: candidate, Weight: 10},
+		Route{Deployable: candidate.WithSchemaSuffix("pinned"), Predicate: func(key string) bool {
+			return strings.HasPrefix(key, "internal-")
+		}},
+	)
+	require.NoError(t, err)
+
+	// The same key always goes to the same version
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("tenant-%d", i)
+		assert.Equal(t, router.Pick(key).Name(), router.Pick(key).Name())
+	}
+	metrics := router.Metrics()
+	assert.Equal(t, 2000, int(metrics[0].Calls+metrics[1].Calls))
+	assert.InDelta(t, 1800, metrics[0].Calls, 150)
+	assert.Equal(t, int64(0), metrics[2].Calls)
+
+	v := router.Pick("internal-1")
+	assert.Equal(t, "pinned", v.Name())
+	assert.Equal(t, "pinned", v.SchemaSuffix())
+	assert.Equal(t, "exec [code@pinned].Foo", v.Patch("exec [code].Foo"))
+	assert.Equal(t, "exec [code@pinned].Foo", v.Patch("exec [code].Foo"))
+
+	// Shift all traffic to the candidate
+	require.NoError(t, router.SetWeight("current", 0))
+	require.NoError(t, router.SetWeight("candidate", 1))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "candidate", router.Pick(fmt.Sprintf("tenant-%d", i)).Name())
+		assert.Equal(t, "candidate", router.Pick("").Name())
+	}
+	assert.Equal(t, []RouteMetrics{
+		{Name: "current", SchemaSuffix: "current1", Weight: 0, Calls: metrics[0].Calls},
+		{Name: "candidate", SchemaSuffix: "candidate2", Weight: 1, Calls: metrics[1].Calls + 200},
+		{Name: "pinned", SchemaSuffix: "pinned", Weight: 0, Calls: 1},
+	}, router.Metrics())
+
+	assert.EqualError(t, router.SetWeight("other", 1), "no route named other")
+	_, err = NewRouter(Route{Deployable: current}, Route{Deployable: current})
+	assert.EqualError(t, err, "NewRouter: duplicate route current1")
+}
@@ -0,0 +1,106 @@
+package sqlcode
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+// SourceMapping maps a position in the preprocessed output of a Batch
+// (line and column relative to the start of the batch, 1-based) back to
+// the position in the input it came from.
+type SourceMapping struct {
+	OutputLine int           `json:"outputLine"`
+	OutputCol  int           `json:"outputCol"`
+	Input      sqlparser.Pos `json:"input"`
+	// Replaced is set if the output at this point is the result of a
+	// substitution (e.g. a constant); all positions within it map to Input.
+	Replaced bool `json:"replaced,omitempty"`
+}
+
+// SourceMap is a list of SourceMapping sorted by output position. Only
+// the points where the mapping changes are included; between them, output
+// maps to input 1:1.
+type SourceMap []SourceMapping
+
+// InputPos maps a position in the output back to the input. ok is false if the
+// position is before the first mapping (e.g. the SourceMap is empty).
+func (m SourceMap) InputPos(line, col int) (pos sqlparser.Pos, ok bool) {
+	i := sort.Search(len(m), func(i int) bool {
+		return m[i].OutputLine > line || (m[i].OutputLine == line && m[i].OutputCol > col)
+	}) - 1
+	if i < 0 {
+		return sqlparser.Pos{}, false
+	}
+	e := m[i]
+	pos = e.Input
+	switch {
+	case e.Replaced:
+	case line == e.OutputLine:
+		pos.Col += col - e.OutputCol
+	default:
+		pos.Line += line - e.OutputLine
+		pos.Col = col
+	}
+	return pos, true
+}
+
+// sourceMapWriter builds up the output of a batch along with its SourceMap
+type sourceMapWriter struct {
+	strings.Builder
+	line, col int
+	sourceMap SourceMap
+}
+
+func newSourceMapWriter() *sourceMapWriter {
+	return &sourceMapWriter{line: 1, col: 1}
+}
+
+// write adds `token` to the output, originating from `input`
+func (w *sourceMapWriter) write(token string, input sqlparser.Pos, replaced bool) {
+	predicted, ok := w.sourceMap.InputPos(w.line, w.col)
+	if replaced || !ok || w.sourceMap[len(w.sourceMap)-1].Replaced || predicted != input {
+		w.sourceMap = append(w.sourceMap, SourceMapping{
+			OutputLine: w.line,
+			OutputCol:  w.col,
+			Input:      input,
+			Replaced:   replaced,
+		})
+	}
+	w.WriteString(token)
+	for _, c := range []byte(token) {
+		if c == '\n' {
+			w.line++
+			w.col = 1
+		} else {
+			w.col++
+		}
+	}
+}
+
+// BatchSourceMap is the serializable source map of one Batch
+type BatchSourceMap struct {
+	Namespace  string    `json:"namespace,omitempty"`
+	QuotedName string    `json:"name"`
+	Mappings   SourceMap `json:"mappings"`
+}
+
+// SourceMap returns the source maps of all batches, suitable for JSON serialization
+func (p PreprocessedFile) SourceMap() (result []BatchSourceMap) {
+	for _, b := range p.Batches {
+		result = append(result, BatchSourceMap{
+			Namespace:  b.Namespace,
+			QuotedName: b.QuotedName,
+			Mappings:   b.SourceMap,
+		})
+	}
+	return
+}
+
+func unquoteName(quotedName string) string {
+	if strings.HasPrefix(quotedName, "[") && strings.HasSuffix(quotedName, "]") {
+		return strings.ReplaceAll(quotedName[1:len(quotedName)-1], "]]", "]")
+	}
+	return quotedName
+}
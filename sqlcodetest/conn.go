@@ -0,0 +1,393 @@
+package sqlcodetest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// conn is a session in the fake
+type conn struct {
+	fake          *Fake
+	tx            *tx
+	impersonating string
+}
+
+type tx struct {
+	c       *conn
+	created map[string]*schema
+	dropped map[string]bool
+	batches []string
+}
+
+var (
+	_ driver.ConnBeginTx    = &conn{}
+	_ driver.ExecerContext  = &conn{}
+	_ driver.QueryerContext = &conn{}
+)
+
+func (c *conn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("sqlcodetest: prepared statements are not supported")
+}
+
+func (c *conn) Close() error {
+	if c.tx != nil {
+		_ = c.tx.Rollback()
+	}
+	// session locks are released when the session ends
+	c.fake.locks.releaseAll(c, "")
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("sqlcodetest: transaction already in progress")
+	}
+	c.tx = &tx{c: c, created: make(map[string]*schema), dropped: make(map[string]bool)}
+	return c.tx, nil
+}
+
+func (t *tx) Commit() error {
+	f := t.c.fake
+	f.mu.Lock()
+	for name := range t.dropped {
+		delete(f.schemas, name)
+	}
+	for name, s := range t.created {
+		for _, batch := range t.batches {
+			if strings.Contains(strings.ToLower(batch), "["+strings.ToLower(name)+"]") {
+				s.objects++
+			}
+		}
+		f.schemas[name] = s
+	}
+	f.mu.Unlock()
+	return t.end()
+}
+
+func (t *tx) Rollback() error {
+	return t.end()
+}
+
+func (t *tx) end() error {
+	f := t.c.fake
+	f.mu.Lock()
+	for name, owner := range f.reserved {
+		if owner == t.c {
+			delete(f.reserved, name)
+		}
+	}
+	f.mu.Unlock()
+	f.locks.releaseAll(t.c, "transaction")
+	t.c.tx = nil
+	return nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, _, err := c.run(ctx, query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows, err := c.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	result := &resultRows{columns: columns, rows: rows}
+	if r, ok := c.fake.response(query); ok {
+		result.types = r.types
+	}
+	return result, nil
+}
+
+var (
+	likeRegexp         = regexp.MustCompile(`(?i)like\s+'([^']*)'`)
+	executeAsRegexp    = regexp.MustCompile(`(?i)execute as user\s*=\s*'([^']*)'`)
+	schemaSuffixRegexp = regexp.MustCompile(`(?i)@schemasuffix\s*=\s*N?'([^']*)'`)
+	namespaceRegexp    = regexp.MustCompile(`(?i)@namespace\s*=\s*N?'([^']*)'`)
+)
+
+// run executes a statement, returning a single result set
+func (c *conn) run(ctx context.Context, query string, namedValues []driver.NamedValue) (columns []string, rows [][]driver.Value, err error) {
+	args := make(map[string]interface{})
+	for _, nv := range namedValues {
+		if nv.Name != "" {
+			args[strings.ToLower(nv.Name)] = nv.Value
+		} else {
+			args[fmt.Sprintf("p%d", nv.Ordinal)] = nv.Value
+		}
+	}
+	if err = c.fake.record(query, args); err != nil {
+		return
+	}
+
+	if r, ok := c.fake.response(query); ok {
+		columns, values := r.f(args)
+		for _, row := range values {
+			converted := make([]driver.Value, len(row))
+			for i, v := range row {
+				converted[i] = v
+			}
+			rows = append(rows, converted)
+		}
+		return columns, rows, nil
+	}
+
+	lower := strings.ToLower(query)
+	switch {
+	case strings.Contains(lower, "sp_getapplock"):
+		return c.getAppLock(ctx, query, args)
+	case strings.Contains(lower, "sp_releaseapplock"):
+		resource := stringArg(args, "resource", "")
+		if !c.fake.locks.release(c, resource) {
+			err = sqlError(1223, "Cannot release the application lock (Database Principal: 'public', Resource: '%s') because it is not currently held.", resource)
+		}
+		return
+	case strings.Contains(lower, "execute as user"):
+		c.impersonating = stringArg(args, "username", stringMatch(executeAsRegexp, query, ""))
+		return []string{""}, [][]driver.Value{{[]byte("cookie")}}, nil
+	case strings.HasPrefix(strings.TrimSpace(lower), "revert"):
+		c.impersonating = ""
+		return
+	case strings.Contains(lower, "insert into sqlcode.version"):
+		if v, ok := args["version"].(int64); ok {
+			c.fake.SetVersion(int(v))
+		}
+		return
+	case strings.Contains(lower, "from sqlcode.version"):
+		return []string{""}, [][]driver.Value{{int64(c.fake.Version())}}, nil
+	case strings.Contains(lower, "schema_id("):
+		name := stringArg(args, "p1", "")
+		var id int64
+		if s := c.schema(name); s != nil {
+			id = int64(s.id)
+		}
+		return []string{""}, [][]driver.Value{{id}}, nil
+	case calls(lower, "sqlcode.createcodeschema"):
+		return nil, nil, c.createCodeSchema(literalArgs(query, args))
+	case calls(lower, "sqlcode.dropcodeschema"):
+		return nil, nil, c.dropCodeSchema(literalArgs(query, args))
+	case strings.Contains(lower, "from sys.schemas"):
+		return c.listSchemas(query)
+	}
+
+	if c.tx != nil {
+		c.tx.batches = append(c.tx.batches, query)
+	}
+	return
+}
+
+func (c *conn) getAppLock(ctx context.Context, query string, args map[string]interface{}) ([]string, [][]driver.Value, error) {
+	ref := lockRef{
+		mode:  stringParam(query, args, "LockMode", "exclusive"),
+		owner: stringParam(query, args, "LockOwner", "transaction"),
+	}
+	if strings.EqualFold(ref.owner, "transaction") && c.tx == nil {
+		return nil, nil, sqlError(1222, "You attempted to acquire a transactional application lock without an active transaction.")
+	}
+	timeoutMs := int64(-1)
+	if v, ok := param(query, args, "LockTimeout").(int64); ok {
+		timeoutMs = v
+	}
+	retcode, err := c.fake.locks.acquire(ctx, c, stringParam(query, args, "Resource", ""), ref, time.Duration(timeoutMs)*time.Millisecond)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []string{""}, [][]driver.Value{{int64(retcode)}}, nil
+}
+
+// schema looks up a schema as seen by this session
+func (c *conn) schema(name string) *schema {
+	if c.tx != nil {
+		if c.tx.dropped[name] {
+			return nil
+		}
+		if s, ok := c.tx.created[name]; ok {
+			return s
+		}
+	}
+	c.fake.mu.Lock()
+	defer c.fake.mu.Unlock()
+	return c.fake.schemas[name]
+}
+
+// literalArgs adds the arguments of `exec sqlcode.XCodeSchema @schemasuffix = '...'`
+// given as literals in the query to args
+func literalArgs(query string, args map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for name, re := range map[string]*regexp.Regexp{"schemasuffix": schemaSuffixRegexp, "namespace": namespaceRegexp} {
+		if m := re.FindStringSubmatch(query); m != nil {
+			result[name] = m[1]
+		}
+	}
+	for name, value := range args {
+		result[name] = value
+	}
+	return result
+}
+
+func codeSchemaName(args map[string]interface{}) string {
+	return stringArg(args, "namespace", "code") + "@" + stringArg(args, "schemasuffix", "")
+}
+
+// checkNamespace validates @namespace as sqlcode.CreateCodeSchema/DropCodeSchema do
+func checkNamespace(args map[string]interface{}) error {
+	namespace := stringArg(args, "namespace", "code")
+	if namespace == "" || namespace == "sqlcode" || strings.Contains(namespace, "@") {
+		return sqlError(55003, "Illegal namespace: %s", namespace)
+	}
+	return nil
+}
+
+func (c *conn) createCodeSchema(args map[string]interface{}) error {
+	if c.tx == nil {
+		return sqlError(55001, "You should run sqlcode.CreateCodeSchema within a transaction")
+	}
+	if err := checkNamespace(args); err != nil {
+		return err
+	}
+	name := codeSchemaName(args)
+	f := c.fake
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, exists := f.schemas[name]
+	owner, reserved := f.reserved[name]
+	if (exists && !c.tx.dropped[name]) || (reserved && owner != c) || c.tx.created[name] != nil {
+		return sqlError(2714, "There is already an object named '%s' in the database.", name)
+	}
+	f.reserved[name] = c
+	c.tx.created[name] = f.newSchema()
+	return nil
+}
+
+func (c *conn) dropCodeSchema(args map[string]interface{}) error {
+	if c.tx == nil {
+		return sqlError(55001, "You should run sqlcode.DropCodeSchema within a transaction")
+	}
+	if err := checkNamespace(args); err != nil {
+		return err
+	}
+	name := codeSchemaName(args)
+	if c.schema(name) == nil {
+		return sqlError(55002, "Schema [%s] not found", name)
+	}
+	if _, ok := c.tx.created[name]; ok {
+		delete(c.tx.created, name)
+	} else {
+		c.tx.dropped[name] = true
+	}
+	return nil
+}
+
+// listSchemas answers queries on sys.schemas, filtered by the first `like` in the
+// query; the columns are those of Deployable.ListUploaded
+func (c *conn) listSchemas(query string) ([]string, [][]driver.Value, error) {
+	pattern := strings.ToLower(stringMatch(likeRegexp, query, "%"))
+	prefix := strings.TrimSuffix(pattern, "%")
+
+	c.fake.mu.Lock()
+	defer c.fake.mu.Unlock()
+	var names []string
+	for name := range c.fake.schemas {
+		if strings.HasPrefix(strings.ToLower(name), prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var rows [][]driver.Value
+	for _, name := range names {
+		s := c.fake.schemas[name]
+		rows = append(rows, []driver.Value{name, int64(s.id), int64(s.objects), s.createDate, s.createDate})
+	}
+	return []string{"name", "schema_id", "objects", "create_date", "modify_date"}, rows, nil
+}
+
+// calls is true if the statement executes the procedure, as opposed to
+// e.g. creating it
+func calls(lower, procedure string) bool {
+	return strings.HasPrefix(strings.TrimSpace(lower), procedure) || strings.Contains(lower, "exec "+procedure)
+}
+
+// param finds the value passed for a parameter of a procedure, as in
+// `exec proc @Param = 'literal'`, `@Param = 123` or `@Param = @arg`
+// (looked up in args), or as the named argument itself
+func param(query string, args map[string]interface{}, name string) interface{} {
+	re := regexp.MustCompile(`(?i)@` + name + `\s*=\s*(?:N?'([^']*)'|(-?\d+)|@(\w+))`)
+	m := re.FindStringSubmatch(query)
+	switch {
+	case m == nil:
+		return args[strings.ToLower(name)]
+	case m[2] != "":
+		n, _ := strconv.ParseInt(m[2], 10, 64)
+		return n
+	case m[3] != "":
+		return args[strings.ToLower(m[3])]
+	}
+	return m[1]
+}
+
+func stringParam(query string, args map[string]interface{}, name string, defaultValue string) string {
+	if s, ok := param(query, args, name).(string); ok {
+		return s
+	}
+	return defaultValue
+}
+
+func stringArg(args map[string]interface{}, name string, defaultValue string) string {
+	if s, ok := args[name].(string); ok {
+		return s
+	}
+	return defaultValue
+}
+
+func stringMatch(re *regexp.Regexp, s string, defaultValue string) string {
+	if m := re.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return defaultValue
+}
+
+type resultRows struct {
+	columns []string
+	types   []string
+	rows    [][]driver.Value
+}
+
+var _ driver.RowsColumnTypeDatabaseTypeName = &resultRows{}
+
+func (r *resultRows) Columns() []string {
+	return r.columns
+}
+
+func (r *resultRows) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(r.types) {
+		return r.types[index]
+	}
+	return ""
+}
+
+func (r *resultRows) Close() error {
+	return nil
+}
+
+func (r *resultRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
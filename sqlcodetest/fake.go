@@ -0,0 +1,266 @@
+// Package sqlcodetest has an in-process fake of the parts of SQL Server that
+// sqlcode itself uses (application locks, schemas, sqlcode.CreateCodeSchema and
+// sqlcode.DropCodeSchema, impersonation), so that code calling
+// Deployable.EnsureUploaded/Upload can be tested without a database.
+//
+// Statements the fake does not know about are recorded and succeed,
+// returning no rows. Errors can be injected with FailOn.
+package sqlcodetest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// Statement is a statement executed against the fake
+type Statement struct {
+	Query string
+	Args  map[string]interface{} // by name; positional arguments as p1, p2, ...
+	Err   error                  // the error returned, if any
+}
+
+type schema struct {
+	id         int
+	objects    int
+	createDate time.Time
+}
+
+type response struct {
+	substring string
+	types     []string
+	f         func(args map[string]interface{}) ([]string, [][]interface{})
+}
+
+type delay struct {
+	substring string
+	duration  time.Duration
+}
+
+type failure struct {
+	substring string
+	err       error
+	once      bool
+}
+
+// Fake is the state of the fake database; see DB()
+type Fake struct {
+	mu           sync.Mutex
+	schemas      map[string]*schema
+	reserved     map[string]*conn // schemas created in transactions not yet committed
+	nextSchemaID int
+	statements   []Statement
+	failures     []failure
+	delays       []delay
+	responses    []response
+	locks        *lockManager
+	version      int
+}
+
+// LibraryVersion is the version of the sqlcode library the fake reports as
+// installed by default; the version of the last migration in the sqlcode package
+const LibraryVersion = 6
+
+func New() *Fake {
+	return &Fake{
+		schemas:      make(map[string]*schema),
+		reserved:     make(map[string]*conn),
+		nextSchemaID: 5, // after the built-in schemas
+		locks:        newLockManager(),
+		version:      LibraryVersion,
+	}
+}
+
+// DB opens a connection pool to the fake; it implements sqlcode.DB
+func (f *Fake) DB() *sql.DB {
+	return sql.OpenDB(connector{f})
+}
+
+// FailOn makes every statement containing substring (case-insensitive) fail with err
+func (f *Fake) FailOn(substring string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, failure{substring: strings.ToLower(substring), err: err})
+}
+
+// FailOnce is FailOn for only the first matching statement
+func (f *Fake) FailOnce(substring string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, failure{substring: strings.ToLower(substring), err: err, once: true})
+}
+
+// Delay makes every statement containing substring (case-insensitive) take
+// (at least) the given time; e.g. to make concurrent calls overlap
+func (f *Fake) Delay(substring string, duration time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delays = append(f.delays, delay{substring: strings.ToLower(substring), duration: duration})
+}
+
+// Respond makes statements containing substring (case-insensitive) return
+// the given result set, instead of what the fake would otherwise do. The
+// latest matching call to Respond wins.
+func (f *Fake) Respond(substring string, columns []string, rows ...[]interface{}) {
+	f.RespondFunc(substring, func(map[string]interface{}) ([]string, [][]interface{}) {
+		return columns, rows
+	})
+}
+
+// RespondTyped is Respond with the database type names of the columns (as
+// in sql.ColumnType.DatabaseTypeName, e.g. UNIQUEIDENTIFIER); they are
+// otherwise empty
+func (f *Fake) RespondTyped(substring string, columns []string, types []string, rows ...[]interface{}) {
+	f.respond(response{substring: strings.ToLower(substring), types: types, f: func(map[string]interface{}) ([]string, [][]interface{}) {
+		return columns, rows
+	}})
+}
+
+// RespondFunc is Respond with the result set computed from the arguments
+// of the statement (see Statement.Args)
+func (f *Fake) RespondFunc(substring string, respond func(args map[string]interface{}) (columns []string, rows [][]interface{})) {
+	f.respond(response{substring: strings.ToLower(substring), f: respond})
+}
+
+func (f *Fake) respond(r response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append([]response{r}, f.responses...)
+}
+
+func (f *Fake) response(query string) (response, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lower := strings.ToLower(query)
+	for _, r := range f.responses {
+		if strings.Contains(lower, r.substring) {
+			return r, true
+		}
+	}
+	return response{}, false
+}
+
+// Statements returns all statements executed so far, in order
+func (f *Fake) Statements() []Statement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Statement(nil), f.statements...)
+}
+
+// Count returns the number of executed statements containing substring (case-insensitive)
+func (f *Fake) Count(substring string) (n int) {
+	for _, s := range f.Statements() {
+		if strings.Contains(strings.ToLower(s.Query), strings.ToLower(substring)) {
+			n++
+		}
+	}
+	return
+}
+
+// Schemas returns the names of the (committed) schemas
+func (f *Fake) Schemas() (result []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name := range f.schemas {
+		result = append(result, name)
+	}
+	return
+}
+
+// LockMode returns the strongest mode ("shared" or "exclusive") any session
+// holds an application lock on resource in, or "" if there are none
+func (f *Fake) LockMode(resource string) string {
+	return f.locks.mode(resource)
+}
+
+// Version returns the version of the sqlcode library installed in the fake,
+// as found by sqlcode.InstalledVersion; 0 if it is not installed
+func (f *Fake) Version() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.version
+}
+
+// SetVersion sets the version of the sqlcode library installed in the fake
+func (f *Fake) SetVersion(version int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version = version
+}
+
+// CreateSchema adds a schema, as if created by sqlcode.CreateCodeSchema
+func (f *Fake) CreateSchema(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.schemas[name] = f.newSchema()
+}
+
+func (f *Fake) newSchema() *schema {
+	f.nextSchemaID++
+	return &schema{id: f.nextSchemaID, createDate: time.Now()}
+}
+
+// record logs the statement, and returns an injected error if there is one
+func (f *Fake) record(query string, args map[string]interface{}) error {
+	lower := strings.ToLower(query)
+	if d := f.delayOf(lower); d > 0 {
+		time.Sleep(d)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var err error
+	for i, fail := range f.failures {
+		if strings.Contains(lower, fail.substring) {
+			err = fail.err
+			if fail.once {
+				f.failures = append(f.failures[:i:i], f.failures[i+1:]...)
+			}
+			break
+		}
+	}
+	f.statements = append(f.statements, Statement{Query: query, Args: args, Err: err})
+	return err
+}
+
+func (f *Fake) delayOf(lower string) (result time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, d := range f.delays {
+		if strings.Contains(lower, d.substring) {
+			result += d.duration
+		}
+	}
+	return
+}
+
+func sqlError(number int32, format string, args ...interface{}) mssql.Error {
+	err := mssql.Error{Number: number, Class: 16, Message: fmt.Sprintf(format, args...)}
+	err.All = []mssql.Error{err}
+	return err
+}
+
+type connector struct {
+	fake *Fake
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{fake: c.fake}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{c.fake}
+}
+
+type fakeDriver struct {
+	fake *Fake
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return &conn{fake: d.fake}, nil
+}
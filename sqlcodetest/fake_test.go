@@ -0,0 +1,88 @@
+package sqlcodetest
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getAppLock(t *testing.T, conn *sql.Conn, mode string, timeoutMs int) (retcode int) {
+	require.NoError(t, conn.QueryRowContext(context.Background(), `
+declare @retcode int;
+exec @retcode = sp_getapplock @Resource = @resource, @LockMode = @mode, @LockOwner = 'Session', @LockTimeout = @timeoutMs;
+select @retcode;
+`, sql.Named("resource", "r"), sql.Named("mode", mode), sql.Named("timeoutMs", timeoutMs)).Scan(&retcode))
+	return
+}
+
+func TestAppLocks(t *testing.T) {
+	ctx := context.Background()
+	fake := New()
+	dbc := fake.DB()
+	dbc.SetMaxIdleConns(0) // so that Close ends the session
+	conn1, err := dbc.Conn(ctx)
+	require.NoError(t, err)
+	conn2, err := dbc.Conn(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, getAppLock(t, conn1, "Shared", 0))
+	assert.Equal(t, 0, getAppLock(t, conn2, "Shared", 0))
+	assert.Equal(t, "shared", fake.LockMode("r"))
+	assert.Equal(t, -1, getAppLock(t, conn2, "Exclusive", 10))
+
+	// Exclusive is granted when conn1 releases; conn2 only holds shared locks
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = conn1.ExecContext(ctx, `sp_releaseapplock`, sql.Named("Resource", "r"))
+	}()
+	assert.Equal(t, 1, getAppLock(t, conn2, "Exclusive", 5000))
+	assert.Equal(t, "exclusive", fake.LockMode("r"))
+
+	_, err = conn1.ExecContext(ctx, `sp_releaseapplock`, sql.Named("Resource", "r"))
+	assert.Error(t, err)
+
+	// Session locks are released when the session ends
+	require.NoError(t, conn2.Close())
+	assert.Equal(t, "", fake.LockMode("r"))
+}
+
+func TestCodeSchemaTransactions(t *testing.T) {
+	ctx := context.Background()
+	fake := New()
+	dbc := fake.DB()
+
+	_, err := dbc.ExecContext(ctx, `sqlcode.CreateCodeSchema`, sql.Named("schemasuffix", "a"))
+	assert.EqualError(t, err, "mssql: You should run sqlcode.CreateCodeSchema within a transaction")
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = tx.ExecContext(ctx, `sqlcode.CreateCodeSchema`, sql.Named("schemasuffix", "a"))
+	require.NoError(t, err)
+	var id int
+	require.NoError(t, tx.QueryRowContext(ctx, `select isnull(schema_id(@p1), 0)`, "code@a").Scan(&id))
+	assert.NotEqual(t, 0, id)
+	// not visible outside the transaction before commit
+	require.NoError(t, dbc.QueryRowContext(ctx, `select isnull(schema_id(@p1), 0)`, "code@a").Scan(&id))
+	assert.Equal(t, 0, id)
+	require.NoError(t, tx.Rollback())
+	assert.Empty(t, fake.Schemas())
+
+	fake.CreateSchema("code@b")
+	tx, err = dbc.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = tx.ExecContext(ctx, `sqlcode.DropCodeSchema`, sql.Named("schemasuffix", "b"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	assert.Empty(t, fake.Schemas())
+
+	// the namespace is validated, so other schemas can not be dropped
+	tx, err = dbc.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = tx.ExecContext(ctx, `sqlcode.DropCodeSchema`, sql.Named("schemasuffix", "b"), sql.Named("namespace", "sqlcode"))
+	assert.EqualError(t, err, "mssql: Illegal namespace: sqlcode")
+	require.NoError(t, tx.Rollback())
+}
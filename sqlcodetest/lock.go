@@ -0,0 +1,153 @@
+package sqlcodetest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockManager simulates sp_getapplock/sp_releaseapplock. Only the
+// 'Shared' mode is compatible with anything (itself); all other modes are
+// treated as 'Exclusive'.
+type lockManager struct {
+	mu      sync.Mutex
+	held    map[string]map[*conn][]lockRef // resource -> holders -> references, in order acquired
+	changed chan struct{}                  // closed and replaced whenever a lock is released
+}
+
+type lockRef struct {
+	mode  string
+	owner string // "session" or "transaction"
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		held:    make(map[string]map[*conn][]lockRef),
+		changed: make(chan struct{}),
+	}
+}
+
+// acquire returns the return code of sp_getapplock; 0 if granted at once,
+// 1 if granted after waiting and -1 on timeout. timeout < 0 waits forever.
+func (m *lockManager) acquire(ctx context.Context, c *conn, resource string, ref lockRef, timeout time.Duration) (int, error) {
+	resource = strings.ToLower(resource)
+	ref.mode = strings.ToLower(ref.mode)
+	ref.owner = strings.ToLower(ref.owner)
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	waited := false
+	for {
+		m.mu.Lock()
+		if m.grantable(c, resource, ref.mode) {
+			if m.held[resource] == nil {
+				m.held[resource] = make(map[*conn][]lockRef)
+			}
+			m.held[resource][c] = append(m.held[resource][c], ref)
+			m.mu.Unlock()
+			if waited {
+				return 1, nil
+			}
+			return 0, nil
+		}
+		changed := m.changed
+		m.mu.Unlock()
+
+		if timeout == 0 {
+			return -1, nil
+		}
+		waited = true
+		select {
+		case <-changed:
+		case <-deadline:
+			return -1, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+func (m *lockManager) grantable(c *conn, resource, mode string) bool {
+	for holder, refs := range m.held[resource] {
+		if holder == c {
+			continue
+		}
+		for _, ref := range refs {
+			if mode != "shared" || ref.mode != "shared" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// release releases the most recent reference c has on the resource; ok is
+// false if it has none
+func (m *lockManager) release(c *conn, resource string) (ok bool) {
+	resource = strings.ToLower(resource)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	refs := m.held[resource][c]
+	if len(refs) == 0 {
+		return false
+	}
+	m.setRefs(c, resource, refs[:len(refs)-1])
+	m.notify()
+	return true
+}
+
+// releaseAll releases all references of c with the given owner; all
+// references if owner is ""
+func (m *lockManager) releaseAll(c *conn, owner string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for resource, holders := range m.held {
+		var kept []lockRef
+		for _, ref := range holders[c] {
+			if owner != "" && ref.owner != owner {
+				kept = append(kept, ref)
+			}
+		}
+		m.setRefs(c, resource, kept)
+	}
+	m.notify()
+}
+
+func (m *lockManager) setRefs(c *conn, resource string, refs []lockRef) {
+	if len(refs) > 0 {
+		m.held[resource][c] = refs
+		return
+	}
+	delete(m.held[resource], c)
+	if len(m.held[resource]) == 0 {
+		delete(m.held, resource)
+	}
+}
+
+func (m *lockManager) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// mode returns the strongest mode held on the resource by anyone, or "" if
+// it is not locked
+func (m *lockManager) mode(resource string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := ""
+	for _, refs := range m.held[strings.ToLower(resource)] {
+		for _, ref := range refs {
+			if ref.mode != "shared" {
+				return ref.mode
+			}
+			result = ref.mode
+		}
+	}
+	return result
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"io"
+	"regexp"
 	"strings"
 )
 
@@ -62,9 +63,36 @@ func (p PosString) String() string {
 type Create struct {
 	CreateType string    // "procedure", "function" or "type"
 	QuotedName PosString // proc/func/type name, including []
-	Body       []Unparsed
-	DependsOn  []PosString
-	Docstring  []PosString // comment lines before the create statement. Note: this is also part of Body
+	Namespace  string    // virtual schema declared by a namespace pragma; empty for [code]
+	// PragmaNamespaces are the namespaces declared in the file of the create
+	// statement; only these (and [code]) are virtual schemas in Body
+	PragmaNamespaces []string
+	Body             []Unparsed
+	DependsOn        []PosString // QualifiedName() of the dependencies
+	Docstring        []PosString // comment lines before the create statement. Note: this is also part of Body
+	Test             bool        // test code; see IsTestProcedure()
+}
+
+// IsTestProcedure returns true for procedures that should be run by the SQL
+// test runner; that is procedures named `[test:...]`, and all procedures in files
+// with the `--sqlcode:test` pragma. Functions and types in such files are
+// test helpers; like the tests they are left out with Options.ExcludeTests.
+func (c Create) IsTestProcedure() bool {
+	return c.Test && c.CreateType == "procedure"
+}
+
+// QualifiedName identifies the create statement across namespaces. Objects in
+// [code] are named by QuotedName only (`[Foo]`), while objects in other
+// namespaces include the namespace (`[billing].[Foo]`).
+func (c Create) QualifiedName() string {
+	return qualifiedName(c.Namespace, c.QuotedName.Value)
+}
+
+func qualifiedName(namespace, quotedName string) string {
+	if namespace == "" {
+		return quotedName
+	}
+	return "[" + namespace + "]." + quotedName
 }
 
 func (c Create) DocstringAsString() string {
@@ -92,6 +120,22 @@ func (c Create) DocstringYamldoc() (string, error) {
 	return strings.Join(yamldoc, "\n"), nil
 }
 
+// DocstringYamlPos is the position of the `--!` line with the given (1-based)
+// line number in the YAML document returned by DocstringYamldoc; the position
+// of the name of the create statement if there is no such line
+func (c Create) DocstringYamlPos(line int) Pos {
+	n := 0
+	for _, l := range c.Docstring {
+		if strings.HasPrefix(l.Value, "--!") {
+			n++
+			if n == line {
+				return l.Pos
+			}
+		}
+	}
+	return c.QuotedName.Pos
+}
+
 func (c Create) ParseYamlInDocstring(out any) error {
 	yamldoc, err := c.DocstringYamldoc()
 	if err != nil {
@@ -127,10 +171,12 @@ func (e Error) WithoutPos() Error {
 }
 
 type Document struct {
-	PragmaIncludeIf []string
-	Creates         []Create
-	Declares        []Declare
-	Errors          []Error
+	PragmaIncludeIf  []string
+	PragmaNamespaces []string
+	PragmaTest       bool
+	Creates          []Create
+	Declares         []Declare
+	Errors           []Error
 }
 
 func (c Create) Serialize(w io.StringWriter) error {
@@ -166,10 +212,13 @@ func (c Create) WithoutPos() Create {
 		body = append(body, x.WithoutPos())
 	}
 	return Create{
-		CreateType: c.CreateType,
-		QuotedName: c.QuotedName,
-		DependsOn:  c.DependsOn,
-		Body:       body,
+		CreateType:       c.CreateType,
+		QuotedName:       c.QuotedName,
+		Namespace:        c.Namespace,
+		PragmaNamespaces: c.PragmaNamespaces,
+		DependsOn:        c.DependsOn,
+		Test:             c.Test,
+		Body:             body,
 	}
 }
 
@@ -203,8 +252,8 @@ func (d Document) WithoutPos() Document {
 }
 
 func (d *Document) Include(other Document) {
-	// Do not copy PragmaIncludeIf, since that is local to a single file.
-	// Its contents is also present in each Create.
+	// Do not copy PragmaIncludeIf, PragmaNamespaces or PragmaTest, since they are
+	// local to a single file. The namespace and test flag are also present in each Create.
 	d.Declares = append(d.Declares, other.Declares...)
 	d.Creates = append(d.Creates, other.Creates...)
 	d.Errors = append(d.Errors, other.Errors...)
@@ -215,16 +264,84 @@ func (d *Document) parseSinglePragma(s *Scanner) {
 	if pragma == "" {
 		return
 	}
+	if pragma == "test" {
+		d.PragmaTest = true
+		return
+	}
 	parts := strings.Split(pragma, " ")
 	if len(parts) != 2 {
 		d.addError(s, "Illegal pragma: "+s.Token())
 		return
 	}
-	if parts[0] != "include-if" {
+	switch parts[0] {
+	case "include-if":
+		d.PragmaIncludeIf = append(d.PragmaIncludeIf, strings.Split(parts[1], ",")...)
+	case "namespace":
+		if !namespaceRegexp.MatchString(parts[1]) || parts[1] == "code" || parts[1] == "sqlcode" {
+			d.addError(s, "Illegal namespace: "+parts[1])
+			return
+		}
+		d.PragmaNamespaces = append(d.PragmaNamespaces, parts[1])
+	default:
 		d.addError(s, "Illegal pragma: "+s.Token())
-		return
 	}
-	d.PragmaIncludeIf = append(d.PragmaIncludeIf, strings.Split(parts[1], ",")...)
+}
+
+var namespaceRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// virtualSchema returns the namespace if `quotedSchema` is [code] or one of the
+// namespaces declared in the file being parsed ("" for [code]), and ok=false otherwise
+func (d *Document) virtualSchema(quotedSchema string) (namespace string, ok bool) {
+	if quotedSchema == "[code]" {
+		return "", true
+	}
+	for _, ns := range d.PragmaNamespaces {
+		if quotedSchema == "["+ns+"]" {
+			return ns, true
+		}
+	}
+	return "", false
+}
+
+// IsVirtualSchema returns true if `quotedSchema` is [code] or one of the
+// namespaces declared in the file of the create statement, the same rule the
+// parser uses for DependsOn
+func (c Create) IsVirtualSchema(quotedSchema string) bool {
+	if quotedSchema == "[code]" {
+		return true
+	}
+	for _, ns := range c.PragmaNamespaces {
+		if quotedSchema == "["+ns+"]" {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Document) isVirtualSchema(quotedSchema string) bool {
+	_, ok := d.virtualSchema(quotedSchema)
+	return ok
+}
+
+// Namespaces returns all virtual schemas used by the create statements of
+// the document, in order of first appearance (which is dependency order after
+// a topological sort). "code" is always included.
+func (d Document) Namespaces() (result []string) {
+	seen := make(map[string]bool)
+	for _, c := range d.Creates {
+		ns := c.Namespace
+		if ns == "" {
+			ns = "code"
+		}
+		if !seen[ns] {
+			seen[ns] = true
+			result = append(result, ns)
+		}
+	}
+	if !seen["code"] {
+		result = append([]string{"code"}, result...)
+	}
+	return
 }
 
 func (d *Document) parsePragmas(s *Scanner) {
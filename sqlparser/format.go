@@ -0,0 +1,212 @@
+package sqlparser
+
+import (
+	"strings"
+)
+
+// Format rewrites SQL source into the canonical sqlcode layout:
+//
+//   - reserved words are lower-cased
+//   - the contents of begin/end and case/end blocks are indented 4 spaces per level
+//   - commas are trailing; a leading comma is moved to the end of the previous line
+//   - `go` is lower-case, alone at the start of its line, and followed by a blank line
+//   - whitespace within a line is collapsed to a single space, trailing
+//     whitespace is removed and at most one blank line is kept in a row
+//
+// Comments, literals, quoted identifiers, pragmas and `--!` YAML docstrings
+// are copied verbatim; only the indentation in front of them may change.
+//
+// Format works directly on the Scanner tokens and does not need the input
+// to parse as sqlcode; but input the scanner cannot make sense of (unterminated
+// literals, double quotes, trailing garbage after `go`) results in an error.
+func Format(file FileRef, input string) (string, error) {
+	tokens, err := scanForFormat(file, input)
+	if err != nil {
+		return "", err
+	}
+	f := formatter{tokens: tokens}
+	f.run()
+	return f.out.String(), nil
+}
+
+type formatToken struct {
+	Type         TokenType
+	RawValue     string
+	ReservedWord string
+}
+
+func (t formatToken) lower() string {
+	return strings.ToLower(t.RawValue)
+}
+
+func scanForFormat(file FileRef, input string) (result []formatToken, err error) {
+	s := NewScanner(file, input)
+	for {
+		tt := s.NextToken()
+		switch tt {
+		case EOFToken:
+			return result, nil
+		case NonUTF8ErrorToken:
+			return nil, Error{s.Start(), "file is not valid UTF-8"}
+		case UnterminatedVarcharLiteralErrorToken:
+			return nil, Error{s.Start(), "unterminated string literal"}
+		case UnterminatedQuotedIdentifierErrorToken:
+			return nil, Error{s.Start(), "unterminated quoted identifier"}
+		case DoubleQuoteErrorToken:
+			return nil, Error{s.Start(), "double quotes are not supported"}
+		case MalformedBatchSeparatorToken:
+			return nil, Error{s.Start(), "`go` should be alone on a line without any comments"}
+		}
+		result = append(result, formatToken{
+			Type:         tt,
+			RawValue:     s.Token(),
+			ReservedWord: s.ReservedWord(),
+		})
+	}
+}
+
+const formatIndent = "    "
+
+type formatter struct {
+	tokens []formatToken
+	out    strings.Builder
+	depth  int
+
+	// last non-whitespace token written to out; nil at start of file
+	prev *formatToken
+
+	// index of a token that should be skipped because it has already been
+	// written (a leading comma moved to the previous line)
+	skip map[int]bool
+}
+
+// nextSignificant returns the index of the first non-whitespace token after i,
+// or -1 if there is none
+func (f *formatter) nextSignificant(i int) int {
+	for j := i + 1; j < len(f.tokens); j++ {
+		if f.tokens[j].Type != WhitespaceToken {
+			return j
+		}
+	}
+	return -1
+}
+
+// opensBlock tells whether token i is `begin` or `case` starting an indented block;
+// `begin tran` and friends are statements, not blocks
+func (f *formatter) opensBlock(i int) bool {
+	switch f.tokens[i].ReservedWord {
+	case "case":
+		return true
+	case "begin":
+		j := f.nextSignificant(i)
+		if j == -1 {
+			return true
+		}
+		switch f.tokens[j].lower() {
+		case "tran", "transaction", "distributed", "dialog", "conversation":
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// closesBlock tells whether token i is an `end` closing a block (and not `end conversation`)
+func (f *formatter) closesBlock(i int) bool {
+	if f.tokens[i].ReservedWord != "end" {
+		return false
+	}
+	j := f.nextSignificant(i)
+	return j == -1 || f.tokens[j].lower() != "conversation"
+}
+
+func (f *formatter) write(i int) {
+	t := &f.tokens[i]
+	switch {
+	case t.Type == BatchSeparatorToken:
+		f.depth = 0
+		f.out.WriteString("go")
+	case t.Type == ReservedWordToken:
+		if f.closesBlock(i) && f.depth > 0 {
+			f.depth--
+		}
+		if f.opensBlock(i) {
+			f.depth++
+		}
+		f.out.WriteString(t.ReservedWord)
+	default:
+		f.out.WriteString(t.RawValue)
+	}
+	if t.Type == CommaToken && i+1 < len(f.tokens) && f.tokens[i+1].Type != WhitespaceToken {
+		f.out.WriteString(" ")
+	}
+	f.prev = t
+}
+
+func (f *formatter) writeWhitespace(i int) {
+	if f.prev == nil || i == len(f.tokens)-1 {
+		// leading and trailing whitespace of the file is dropped
+		return
+	}
+	ws := f.tokens[i].RawValue
+	next := f.tokens[i+1] // whitespace tokens are maximal, so this is never whitespace
+
+	newlines := strings.Count(ws, "\n")
+	if newlines == 0 {
+		switch {
+		case f.prev.Type == LeftParenToken || f.prev.Type == DotToken:
+		case next.Type == RightParenToken || next.Type == CommaToken ||
+			next.Type == SemicolonToken || next.Type == DotToken:
+		default:
+			f.out.WriteString(" ")
+		}
+		return
+	}
+
+	if next.Type == CommaToken && f.prev.Type != SinglelineCommentToken && f.prev.Type != PragmaToken {
+		// leading comma; move it to the end of the previous line, and drop
+		// any whitespace on the line after it, since we are about to write a fresh indent
+		f.out.WriteString(",")
+		f.skip[i+1] = true
+		if i+2 < len(f.tokens) && f.tokens[i+2].Type == WhitespaceToken {
+			f.skip[i+2] = true
+		}
+	}
+
+	if newlines > 2 {
+		newlines = 2
+	}
+	switch {
+	case next.Type == BatchSeparatorToken:
+		newlines = 1
+	case f.prev.Type == BatchSeparatorToken:
+		newlines = 2
+	}
+	f.out.WriteString(strings.Repeat("\n", newlines))
+
+	depth := f.depth
+	switch {
+	case next.Type == BatchSeparatorToken:
+		depth = 0
+	case f.closesBlock(i+1) && depth > 0:
+		depth--
+	}
+	f.out.WriteString(strings.Repeat(formatIndent, depth))
+}
+
+func (f *formatter) run() {
+	f.skip = make(map[int]bool)
+	for i, t := range f.tokens {
+		if f.skip[i] {
+			continue
+		}
+		if t.Type == WhitespaceToken {
+			f.writeWhitespace(i)
+		} else {
+			f.write(i)
+		}
+	}
+	if f.prev != nil {
+		f.out.WriteString("\n")
+	}
+}
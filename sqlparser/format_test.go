@@ -0,0 +1,99 @@
+package sqlparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	input := `
+
+--sqlcode:include-if one
+declare    @EnumA varchar(max) = N'this  is a
+
+test',    @EnumB tinyint = 5   ;
+GO
+-- Docstring is kept
+--! timeoutMs: 400
+--!   nested:   [1,  2]
+CREATE   PROCEDURE [code] . Foo(  @a int ,@b int  )   AS
+BEGIN
+   SET NOCOUNT ON;   -- trailing comment
+        select
+          x
+          , y /* keep   this */
+          ,z
+        from [My  Table]
+   if @a = 1 begin
+   select case when @b = 1 then 'yes  ' else 'no' end
+   end
+
+
+   begin tran
+        commit
+END
+  go
+
+
+create type [code].MyType as table (x int not null primary key);
+`
+	expected := `--sqlcode:include-if one
+declare @EnumA varchar(max) = N'this  is a
+
+test', @EnumB tinyint = 5;
+go
+
+-- Docstring is kept
+--! timeoutMs: 400
+--!   nested:   [1,  2]
+create procedure [code].Foo(@a int, @b int) as
+begin
+    set NOCOUNT on; -- trailing comment
+    select
+    x,
+    y /* keep   this */,
+    z
+    from [My  Table]
+    if @a = 1 begin
+        select case when @b = 1 then 'yes  ' else 'no' end
+    end
+
+    begin tran
+    commit
+end
+go
+
+create type [code].MyType as table (x int not null primary key);
+`
+	formatted, err := Format("test.sql", input)
+	require.NoError(t, err)
+	assert.Equal(t, expected, formatted)
+
+	// Formatting is idempotent
+	again, err := Format("test.sql", formatted)
+	require.NoError(t, err)
+	assert.Equal(t, formatted, again)
+
+	// ...and does not change how the file parses
+	assert.Equal(t,
+		ParseString("test.sql", input).Creates[0].DocstringAsString(),
+		ParseString("test.sql", formatted).Creates[0].DocstringAsString())
+}
+
+func TestFormatLeadingCommaAfterComment(t *testing.T) {
+	// A leading comma after a single line comment can't be moved without
+	// commenting it out, so it is left in place
+	formatted, err := Format("test.sql", "select a -- the a\n  , b")
+	require.NoError(t, err)
+	assert.Equal(t, "select a -- the a\n, b\n", formatted)
+}
+
+func TestFormatErrors(t *testing.T) {
+	_, err := Format("test.sql", "select 'unterminated")
+	assert.Equal(t, "test.sql:1:8 unterminated string literal", err.Error())
+
+	_, err = Format("test.sql", "select 1\ngo select 2")
+	assert.Equal(t, "test.sql:2:4 `go` should be alone on a line without any comments", err.Error())
+}
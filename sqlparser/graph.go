@@ -0,0 +1,262 @@
+package sqlparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Graph is the dependency graph of create statements, built from
+// Create.DependsOn; see NewGraph
+type Graph struct {
+	Nodes  []*Node // in the order given to NewGraph
+	byName map[string]*Node
+	folded map[string]*Node // byName, with lower-case names
+}
+
+// Node is a create statement in a Graph
+type Node struct {
+	Create
+	Uses   []*Node  // the nodes this one depends on
+	UsedBy []*Node  // the nodes depending on this one
+	Extern []string // dependencies not in the graph, e.g. on imports
+}
+
+// Name is the QualifiedName of the create statement
+func (n *Node) Name() string {
+	return n.QualifiedName()
+}
+
+// NewGraph builds the dependency graph of the create statements
+func NewGraph(creates []Create) *Graph {
+	g := &Graph{byName: make(map[string]*Node), folded: make(map[string]*Node)}
+	for _, c := range creates {
+		n := &Node{Create: c}
+		g.Nodes = append(g.Nodes, n)
+		g.byName[c.QualifiedName()] = n
+		if _, ok := g.folded[strings.ToLower(c.QualifiedName())]; !ok {
+			g.folded[strings.ToLower(c.QualifiedName())] = n
+		}
+	}
+	for _, n := range g.Nodes {
+		seen := make(map[string]bool)
+		for _, dep := range n.DependsOn {
+			if seen[dep.Value] {
+				continue
+			}
+			seen[dep.Value] = true
+			if used, ok := g.byName[dep.Value]; ok {
+				n.Uses = append(n.Uses, used)
+				used.UsedBy = append(used.UsedBy, n)
+			} else {
+				n.Extern = append(n.Extern, dep.Value)
+			}
+		}
+	}
+	return g
+}
+
+// Node finds a node by QualifiedName; the brackets may be left out, as in
+// `Foo` or `billing.Foo`, and `[code].` is ignored. Names are compared
+// ignoring case, like SQL Server does, but an exact match is preferred.
+func (g *Graph) Node(name string) (*Node, bool) {
+	if n, ok := g.byName[name]; ok {
+		return n, true
+	}
+	var parts []string
+	for _, part := range strings.Split(name, ".") {
+		part = strings.TrimSpace(part)
+		if !strings.HasPrefix(part, "[") {
+			part = "[" + part + "]"
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) > 1 && strings.EqualFold(parts[0], "[code]") {
+		parts = parts[1:]
+	}
+	name = strings.Join(parts, ".")
+	if n, ok := g.byName[name]; ok {
+		return n, true
+	}
+	n, ok := g.folded[strings.ToLower(name)]
+	return n, ok
+}
+
+// Subgraph is the graph of the nodes that keep returns true for; edges to
+// other nodes are dropped
+func (g *Graph) Subgraph(keep func(*Node) bool) *Graph {
+	var creates []Create
+	kept := make(map[string]bool)
+	for _, n := range g.Nodes {
+		if keep(n) {
+			kept[n.Name()] = true
+		}
+	}
+	for _, n := range g.Nodes {
+		if !kept[n.Name()] {
+			continue
+		}
+		c := n.Create
+		c.DependsOn = nil
+		for _, dep := range n.DependsOn {
+			if _, inGraph := g.byName[dep.Value]; kept[dep.Value] || !inGraph {
+				c.DependsOn = append(c.DependsOn, dep)
+			}
+		}
+		creates = append(creates, c)
+	}
+	return NewGraph(creates)
+}
+
+// Reachable returns the names of the nodes reachable from the given nodes
+// (including themselves), following Uses, or UsedBy if reverse is set
+func (g *Graph) Reachable(from []*Node, reverse bool) map[string]bool {
+	result := make(map[string]bool)
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		if result[n.Name()] {
+			return
+		}
+		result[n.Name()] = true
+		next := n.Uses
+		if reverse {
+			next = n.UsedBy
+		}
+		for _, m := range next {
+			visit(m)
+		}
+	}
+	for _, n := range from {
+		visit(n)
+	}
+	return result
+}
+
+// WriteText writes the nodes with what they use and are used by
+func (g *Graph) WriteText(w io.Writer) error {
+	for _, n := range g.Nodes {
+		if _, err := fmt.Fprintf(w, "%s (%s, %s:%d):\n", n.Name(), n.CreateType, n.QuotedName.File, n.QuotedName.Line); err != nil {
+			return err
+		}
+		for _, list := range []struct {
+			title string
+			names []string
+		}{
+			{"Uses", append(names(n.Uses), n.Extern...)},
+			{"Used by", names(n.UsedBy)},
+		} {
+			if len(list.names) == 0 {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "  %s:\n", list.title); err != nil {
+				return err
+			}
+			for _, name := range list.names {
+				if _, err := fmt.Fprintf(w, "    %s\n", name); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDOT writes the graph in the DOT language of Graphviz; edges point
+// from the user to the used
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph sqlcode {\n    rankdir=LR;\n    node [shape=box];\n")
+	for _, n := range g.Nodes {
+		b.WriteString(fmt.Sprintf("    %s [label=%s, tooltip=%s];\n", dotID(n.Name()), dotID(n.Name()+"\n"+n.CreateType),
+			dotID(fmt.Sprintf("%s:%d", n.QuotedName.File, n.QuotedName.Line))))
+	}
+	for _, n := range g.Nodes {
+		for _, used := range n.Uses {
+			b.WriteString(fmt.Sprintf("    %s -> %s;\n", dotID(n.Name()), dotID(used.Name())))
+		}
+		for _, extern := range n.Extern {
+			b.WriteString(fmt.Sprintf("    %s -> %s [style=dashed];\n", dotID(n.Name()), dotID(extern)))
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotID(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart
+func (g *Graph) WriteMermaid(w io.Writer) error {
+	ids := make(map[string]string)
+	id := func(name string) string {
+		if _, ok := ids[name]; !ok {
+			ids[name] = fmt.Sprintf("n%d", len(ids))
+		}
+		return ids[name]
+	}
+	label := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, n := range g.Nodes {
+		b.WriteString(fmt.Sprintf("    %s[%s]\n", id(n.Name()), label(n.Name())))
+	}
+	for _, n := range g.Nodes {
+		for _, used := range n.Uses {
+			b.WriteString(fmt.Sprintf("    %s --> %s\n", id(n.Name()), id(used.Name())))
+		}
+		for _, extern := range n.Extern {
+			b.WriteString(fmt.Sprintf("    %s -.-> %s[%s]\n", id(n.Name()), id(extern), label(extern)))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the nodes as JSON
+func (g *Graph) WriteJSON(w io.Writer) error {
+	type jsonNode struct {
+		Name   string   `json:"name"`
+		Type   string   `json:"type"`
+		File   string   `json:"file"`
+		Line   int      `json:"line"`
+		Uses   []string `json:"uses"`
+		UsedBy []string `json:"usedBy"`
+		Extern []string `json:"extern,omitempty"`
+	}
+	result := struct {
+		Nodes []jsonNode `json:"nodes"`
+	}{Nodes: []jsonNode{}}
+	for _, n := range g.Nodes {
+		result.Nodes = append(result.Nodes, jsonNode{
+			Name:   n.Name(),
+			Type:   n.CreateType,
+			File:   string(n.QuotedName.File),
+			Line:   n.QuotedName.Line,
+			Uses:   append([]string{}, names(n.Uses)...),
+			UsedBy: append([]string{}, names(n.UsedBy)...),
+			Extern: n.Extern,
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// names returns the sorted names of the nodes
+func names(nodes []*Node) (result []string) {
+	for _, n := range nodes {
+		result = append(result, n.Name())
+	}
+	sort.Strings(result)
+	return
+}
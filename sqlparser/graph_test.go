@@ -0,0 +1,99 @@
+package sqlparser
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testGraph(t *testing.T) *Graph {
+	doc := ParseString("a.sql", `
+create procedure [code].A as exec [code].B; select [code].C()
+go
+create procedure [code].B as select [code].C()
+go
+create function [code].C() returns int as begin return 1 end
+go
+create procedure [code].Unused as select 1
+`)
+	require.Empty(t, doc.Errors)
+	return NewGraph(doc.Creates)
+}
+
+func TestGraph(t *testing.T) {
+	g := testGraph(t)
+	c, ok := g.Node("C")
+	require.True(t, ok)
+	assert.Equal(t, []string{"[A]", "[B]"}, names(c.UsedBy))
+	_, ok = g.Node("[code].[B]")
+	assert.True(t, ok)
+	_, ok = g.Node("D")
+	assert.False(t, ok)
+	unused, ok := g.Node("[CODE].unused")
+	require.True(t, ok)
+	assert.Equal(t, "[Unused]", unused.Name())
+
+	b, _ := g.Node("B")
+	assert.Equal(t, map[string]bool{"[B]": true, "[C]": true}, g.Reachable([]*Node{b}, false))
+	assert.Equal(t, map[string]bool{"[B]": true, "[A]": true}, g.Reachable([]*Node{b}, true))
+
+	sub := g.Subgraph(func(n *Node) bool { return n.Name() != "[B]" })
+	a, _ := sub.Node("A")
+	assert.Equal(t, []string{"[C]"}, names(a.Uses))
+	assert.Empty(t, a.Extern)
+}
+
+func TestGraphFormats(t *testing.T) {
+	g := testGraph(t).Subgraph(func(n *Node) bool { return n.Name() != "[Unused]" })
+
+	var buf bytes.Buffer
+	require.NoError(t, g.WriteDOT(&buf))
+	assert.Equal(t, `digraph sqlcode {
+    rankdir=LR;
+    node [shape=box];
+    "[A]" [label="[A]\nprocedure", tooltip="a.sql:2"];
+    "[B]" [label="[B]\nprocedure", tooltip="a.sql:4"];
+    "[C]" [label="[C]\nfunction", tooltip="a.sql:6"];
+    "[A]" -> "[B]";
+    "[A]" -> "[C]";
+    "[B]" -> "[C]";
+}
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, g.WriteMermaid(&buf))
+	assert.Equal(t, `flowchart LR
+    n0["[A]"]
+    n1["[B]"]
+    n2["[C]"]
+    n0 --> n1
+    n0 --> n2
+    n1 --> n2
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, g.WriteText(&buf))
+	assert.Equal(t, `[A] (procedure, a.sql:2):
+  Uses:
+    [B]
+    [C]
+
+[B] (procedure, a.sql:4):
+  Uses:
+    [C]
+  Used by:
+    [A]
+
+[C] (function, a.sql:6):
+  Used by:
+    [A]
+    [B]
+
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, g.Subgraph(func(n *Node) bool { return n.Name() == "[B]" }).WriteJSON(&buf))
+	assert.JSONEq(t, `{"nodes": [{"name": "[B]", "type": "procedure", "file": "a.sql", "line": 4, "uses": [], "usedBy": []}]}`, buf.String())
+}
@@ -0,0 +1,145 @@
+package sqlparser
+
+import (
+	"strings"
+)
+
+// Parameter is a parameter in the signature of a procedure or function
+type Parameter struct {
+	Pos        Pos
+	Name       string // including @
+	Type       Type   // BaseType as written, e.g. `varchar` or `[code].MyTableType`; Args e.g. ["max"]
+	HasDefault bool
+	Output     bool
+	ReadOnly   bool
+}
+
+// Parameters returns the parameters in the signature of a procedure or
+// function; nil for types
+func (c Create) Parameters() (result []Parameter) {
+	if c.CreateType != "procedure" && c.CreateType != "function" {
+		return nil
+	}
+
+	// the tokens after the name, without whitespace and comments
+	var tokens []Unparsed
+	afterName := false
+	for _, t := range c.Body {
+		if !afterName {
+			afterName = t.Start == c.QuotedName.Pos
+			continue
+		}
+		switch t.Type {
+		case WhitespaceToken, MultilineCommentToken, SinglelineCommentToken:
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+
+	is := func(t Unparsed, word string) bool {
+		return strings.EqualFold(t.RawValue, word)
+	}
+	// the parameters of functions are always in parentheses, those of
+	// procedures optionally
+	i := 0
+	parens := len(tokens) > 0 && tokens[0].Type == LeftParenToken
+	if parens {
+		i++
+	}
+	var current *Parameter
+	for ; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case parens && t.Type == RightParenToken,
+			!parens && (is(t, "as") || is(t, "with")) && (current == nil || current.Type.BaseType != ""):
+			// end of the parameters; `with` starts the options of the procedure
+			if current != nil {
+				result = append(result, *current)
+			}
+			return
+		case t.Type == LeftParenToken && current != nil:
+			// arguments of the type, e.g. varchar(max) or decimal(18, 2)
+			for i++; i < len(tokens) && tokens[i].Type != RightParenToken; i++ {
+				if tokens[i].Type != CommaToken {
+					current.Type.Args = append(current.Type.Args, tokens[i].RawValue)
+				}
+			}
+		case t.Type == VariableIdentifierToken && current == nil:
+			current = &Parameter{Pos: t.Start, Name: t.RawValue}
+		case current == nil:
+			continue
+		case t.Type == CommaToken:
+			result = append(result, *current)
+			current = nil
+		case t.Type == EqualToken:
+			current.HasDefault = true
+			// skip the default value
+			for i+1 < len(tokens) && tokens[i+1].Type != CommaToken && tokens[i+1].Type != RightParenToken &&
+				!is(tokens[i+1], "output") && !is(tokens[i+1], "out") && !is(tokens[i+1], "readonly") &&
+				!is(tokens[i+1], "as") && !is(tokens[i+1], "with") {
+				i++
+			}
+		case is(t, "output") || is(t, "out"):
+			current.Output = true
+		case is(t, "readonly"):
+			current.ReadOnly = true
+		case is(t, "as") && current.Type.BaseType == "":
+			// `@x as int`
+		case t.Type == DotToken:
+			current.Type.BaseType += "."
+		case current.Type.BaseType == "" || strings.HasSuffix(current.Type.BaseType, "."):
+			current.Type.BaseType += t.RawValue
+		}
+	}
+	if current != nil {
+		result = append(result, *current)
+	}
+	return
+}
+
+// Returns returns what a function returns as written (with whitespace
+// collapsed and comments removed), e.g. `int`, `table` for inline
+// table-valued functions, or `@result table (id int, name nvarchar(100))`;
+// "" for procedures and types
+func (c Create) Returns() string {
+	if c.CreateType != "function" {
+		return ""
+	}
+	var result strings.Builder
+	afterName, returns := false, false
+	depth := 0
+	for _, t := range c.Body {
+		if !afterName {
+			afterName = t.Start == c.QuotedName.Pos
+			continue
+		}
+		switch t.Type {
+		case LeftParenToken:
+			depth++
+		case RightParenToken:
+			depth--
+		case MultilineCommentToken, SinglelineCommentToken:
+			continue
+		}
+		if depth > 0 || t.Type == RightParenToken {
+			if returns {
+				result.WriteString(t.RawValue)
+			}
+			continue
+		}
+		switch {
+		case !returns:
+			returns = strings.EqualFold(t.RawValue, "returns")
+		case strings.EqualFold(t.RawValue, "as") || strings.EqualFold(t.RawValue, "with"):
+			return collapseWhitespace(result.String())
+		default:
+			result.WriteString(t.RawValue)
+		}
+	}
+	return collapseWhitespace(result.String())
+}
+
+func collapseWhitespace(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.ReplaceAll(strings.ReplaceAll(s, "( ", "("), " )", ")")
+}
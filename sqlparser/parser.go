@@ -334,15 +334,17 @@ func (d *Document) recoverToNextStatement(s *Scanner) {
 	}
 }
 
-// parseCodeschemaName parses `[code] . something`, and returns `something`
+// parseCodeschemaName parses `[code] . something` (or another virtual schema
+// declared by a namespace pragma), and returns `something`
 // in quoted form (`[something]`). Also copy to `target`. Empty string on error.
 // Note: To follow conventions, consume one extra token at the end even if we know
 // it fill not be consumed by this function...
 func (d *Document) parseCodeschemaName(s *Scanner, target *[]Unparsed) PosString {
+	schema := s.Token()
 	CopyToken(s, target)
 	NextTokenCopyingWhitespace(s, target)
 	if s.TokenType() != DotToken {
-		d.addError(s, fmt.Sprintf("[code] must be followed by '.'"))
+		d.addError(s, fmt.Sprintf("%s must be followed by '.'", schema))
 		d.recoverToNextStatementCopying(s, target)
 		return PosString{Value: ""}
 	}
@@ -362,7 +364,7 @@ func (d *Document) parseCodeschemaName(s *Scanner, target *[]Unparsed) PosString
 		NextTokenCopyingWhitespace(s, target)
 		return result
 	default:
-		d.addError(s, fmt.Sprintf("[code]. must be followed an identifier"))
+		d.addError(s, fmt.Sprintf("%s. must be followed an identifier", schema))
 		d.recoverToNextStatementCopying(s, target)
 		return PosString{Value: ""}
 	}
@@ -402,16 +404,20 @@ func (d *Document) parseCreate(s *Scanner, createCountInBatch int) (result Creat
 
 	NextTokenCopyingWhitespace(s, &result.Body)
 
-	// Insist on [code].
-	if s.TokenType() != QuotedIdentifierToken || s.Token() != "[code]" {
+	// Insist on [code]. (or a declared namespace)
+	namespace, ok := d.virtualSchema(s.Token())
+	if s.TokenType() != QuotedIdentifierToken || !ok {
 		d.addError(s, fmt.Sprintf("create %s must be followed by [code].", result.CreateType))
 		d.recoverToNextStatementCopying(s, &result.Body)
 		return
 	}
+	result.Namespace = namespace
+	result.PragmaNamespaces = d.PragmaNamespaces
 	result.QuotedName = d.parseCodeschemaName(s, &result.Body)
 	if result.QuotedName.String() == "" {
 		return
 	}
+	result.Test = d.PragmaTest || strings.HasPrefix(strings.ToLower(result.QuotedName.Value), "[test:")
 
 	// We have matched "create <createType> [code].<quotedName>"; at this
 	// point we copy the rest until the batch ends; *but* track dependencies
@@ -457,9 +463,13 @@ tailloop:
 
 		case tt == EOFToken || tt == BatchSeparatorToken:
 			break tailloop
-		case tt == QuotedIdentifierToken && s.Token() == "[code]":
+		case tt == QuotedIdentifierToken && d.isVirtualSchema(s.Token()):
 			// Parse a dependency
+			namespace, _ := d.virtualSchema(s.Token())
 			dep := d.parseCodeschemaName(s, &result.Body)
+			if dep.Value != "" {
+				dep.Value = qualifiedName(namespace, dep.Value)
+			}
 			found := false
 			for _, existing := range result.DependsOn {
 				if existing.Value == dep.Value {
@@ -553,6 +563,32 @@ func ParseString(filename FileRef, input string) (result Document) {
 	return
 }
 
+// SplitBatches splits input into batches on the `go` batch separator, the way
+// sqlcmd/SSMS would. The separator lines are not included, and empty batches
+// are skipped.
+func SplitBatches(file FileRef, input string) (batches []string, err error) {
+	s := NewScanner(file, input)
+	var batch strings.Builder
+	flush := func() {
+		if strings.TrimSpace(batch.String()) != "" {
+			batches = append(batches, batch.String())
+		}
+		batch.Reset()
+	}
+	for s.NextToken() != EOFToken {
+		switch s.TokenType() {
+		case BatchSeparatorToken:
+			flush()
+		case MalformedBatchSeparatorToken:
+			return nil, Error{s.Start(), "`go` should be alone on a line without any comments"}
+		default:
+			batch.WriteString(s.Token())
+		}
+	}
+	flush()
+	return
+}
+
 // ParseFileystems iterates through a list of filesystems and parses all files
 // matching `*.sql`, determines which one are sqlcode files from the contents,
 // and returns the combination of all of them.
@@ -593,7 +629,7 @@ func ParseFilesystems(fslst []fs.FS, includeTags []string) (filenames []string,
 				// for this, because the parser can be thrown off by errors, and we can't have
 				// a system where files are suddenly ignored when there are syntax errors!
 				// So using a more stable regex
-				if isSqlCodeRegex.Find(buf) != nil {
+				if IsSqlcodeFile(buf) {
 
 					// protect against same file being referenced from 2 identical file systems..or just same file included twice
 					pathDesc := fmt.Sprintf("fs[%d]:%s", fidx, path)
@@ -663,3 +699,9 @@ func IsSqlcodeConstVariable(varname string) bool {
 // consider something a "sqlcode source file" if it contains [code]
 // or a --sqlcode: header
 var isSqlCodeRegex = regexp.MustCompile(`^--sqlcode:|\[code\]`)
+
+// IsSqlcodeFile sniffs whether the contents of a *.sql-file should be
+// treated as sqlcode source
+func IsSqlcodeFile(buf []byte) bool {
+	return isSqlCodeRegex.Find(buf) != nil
+}
@@ -354,6 +354,9 @@ create procedure [code].Foo as begin end
 	}
 	require.NoError(t, doc.Creates[0].ParseYamlInDocstring(&x))
 	assert.Equal(t, "a", x.Key1)
+
+	assert.Equal(t, Pos{File: "test.sql", Line: 7, Col: 1}, doc.Creates[0].DocstringYamlPos(2))
+	assert.Equal(t, doc.Creates[0].QuotedName.Pos, doc.Creates[0].DocstringYamlPos(4))
 }
 
 func TestCreateAnnotationAfterPragma(t *testing.T) {
@@ -392,3 +395,164 @@ create procedure [code].Foo as begin end
 		err.Error())
 
 }
+
+func TestNamespacePragma(t *testing.T) {
+	doc := ParseString("test.sql", `--sqlcode:namespace billing
+
+create type [billing].Amount as table (x int);
+go
+create procedure [billing].Charge(@a [billing].Amount readonly) as begin
+    select [code].Helper(1)
+end
+go
+create function [code].Helper(@x int) returns int as begin return [billing].[Rate]() end
+`)
+	require.Empty(t, doc.Errors)
+	assert.Equal(t, []string{"billing"}, doc.PragmaNamespaces)
+	require.Equal(t, 3, len(doc.Creates))
+	assert.Equal(t, "billing", doc.Creates[0].Namespace)
+	assert.Equal(t, "[billing].[Amount]", doc.Creates[0].QualifiedName())
+	assert.Equal(t, []string{"[Helper]", "[billing].[Amount]"}, doc.Creates[1].DependsOnStrings())
+	assert.Equal(t, "", doc.Creates[2].Namespace)
+	assert.Equal(t, "[Helper]", doc.Creates[2].QualifiedName())
+	assert.Equal(t, []string{"[billing].[Rate]"}, doc.Creates[2].DependsOnStrings())
+	assert.Equal(t, []string{"billing", "code"}, doc.Namespaces())
+
+	// Without the pragma, [billing] is an ordinary schema
+	doc = ParseString("test.sql", `create procedure [code].Foo as select [billing].Charge()`)
+	require.Empty(t, doc.Errors)
+	assert.Empty(t, doc.Creates[0].DependsOn)
+	assert.Equal(t, []string{"code"}, doc.Namespaces())
+
+	doc = ParseString("test.sql", `--sqlcode:namespace sqlcode`)
+	assert.Equal(t, []Error{{Message: "Illegal namespace: sqlcode"}}, doc.WithoutPos().Errors)
+}
+
+func TestTestPragma(t *testing.T) {
+	doc := ParseString("test.sql", `create procedure [code].[test:Foo works] as begin
+    exec sqlcode.AssertEquals 1, 1
+end
+go
+create procedure [code].Foo as select 1
+`)
+	require.Empty(t, doc.Errors)
+	assert.True(t, doc.Creates[0].IsTestProcedure())
+	assert.False(t, doc.Creates[1].Test)
+
+	doc = ParseString("test.sql", `--sqlcode:test
+create function [code].Helper() returns int as begin return 1 end
+go
+create procedure [code].FooWorks as begin
+    exec sqlcode.AssertEquals 1, 1
+end
+`)
+	require.Empty(t, doc.Errors)
+	assert.True(t, doc.PragmaTest)
+	assert.True(t, doc.Creates[0].Test)
+	assert.False(t, doc.Creates[0].IsTestProcedure())
+	assert.True(t, doc.Creates[1].IsTestProcedure())
+}
+
+func TestSplitBatches(t *testing.T) {
+	batches, err := SplitBatches("test.sql", "select 1\ngo\nselect 'go\ngo'\n  GO  \n\ngo\nselect 3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"select 1\n", "\nselect 'go\ngo'\n  ", "\nselect 3"}, batches)
+
+	_, err = SplitBatches("test.sql", "select 1\ngo -- comment\n")
+	assert.EqualError(t, err, "test.sql:2:4 `go` should be alone on a line without any comments")
+}
+
+func TestCreateResultSet(t *testing.T) {
+	doc := ParseString("test.sql", `
+--! resultset:
+--!   - {name: OrderID, type: bigint}
+--!   - {name: Comment, type: nvarchar(200), nullable: true}
+create procedure [code].ListOrders as select 1
+go
+create procedure [code].NoResultSet as select 1
+go
+--! resultset: [{name: x, type: int}, {name: X, type: int}]
+create procedure [code].Twice as select 1
+go
+--! resultset: [{name: x, type: int}]
+create function [code].F() returns int as begin return 1 end
+`)
+	require.Empty(t, doc.Errors)
+
+	rs, err := doc.Creates[0].ResultSet()
+	require.NoError(t, err)
+	assert.Equal(t, &ResultSet{
+		Pos: Pos{File: "test.sql", Line: 2, Col: 1},
+		Columns: []ResultSetColumn{
+			{Name: "OrderID", Type: "bigint"},
+			{Name: "Comment", Type: "nvarchar(200)", Nullable: true},
+		},
+	}, rs)
+	assert.True(t, rs.Columns[1].SameType("NVARCHAR( 200 )"))
+
+	rs, err = doc.Creates[1].ResultSet()
+	assert.NoError(t, err)
+	assert.Nil(t, rs)
+
+	_, err = doc.Creates[2].ResultSet()
+	assert.EqualError(t, err, "test.sql:9:1 resultset: column X is declared twice")
+	_, err = doc.Creates[3].ResultSet()
+	assert.EqualError(t, err, "test.sql:12:1 resultset is only supported for procedures")
+}
+
+func TestCreateParameters(t *testing.T) {
+	doc := ParseString("test.sql", `
+create procedure [code].[GET:/orders/{id}] @id bigint, @Kind as varchar(max) = 'all' /* comment */,
+    @amount decimal(18, 2) = -1 output, @rows [code].OrderRows readonly
+with execute as owner
+as select 1
+go
+create procedure [code].NoParams as select 1
+go
+create procedure [code].InParens (@a int = null) as select 1
+go
+create function [code].F(@x nvarchar(10), @y int) returns int as begin return 1 end
+go
+create type [code].OrderRows as table (x int)
+`)
+	require.Empty(t, doc.Errors)
+
+	assert.Equal(t, []Parameter{
+		{Pos: Pos{File: "test.sql", Line: 2, Col: 44}, Name: "@id", Type: Type{BaseType: "bigint"}},
+		{Pos: Pos{File: "test.sql", Line: 2, Col: 56}, Name: "@Kind", Type: Type{BaseType: "varchar", Args: []string{"max"}}, HasDefault: true},
+		{Pos: Pos{File: "test.sql", Line: 3, Col: 5}, Name: "@amount", Type: Type{BaseType: "decimal", Args: []string{"18", "2"}}, HasDefault: true, Output: true},
+		{Pos: Pos{File: "test.sql", Line: 3, Col: 41}, Name: "@rows", Type: Type{BaseType: "[code].OrderRows"}, ReadOnly: true},
+	}, doc.Creates[0].Parameters())
+	assert.Empty(t, doc.Creates[1].Parameters())
+
+	var names []string
+	for _, c := range doc.Creates[2:] {
+		for _, p := range c.Parameters() {
+			names = append(names, p.Name+" "+p.Type.BaseType+fmt.Sprint(p.Type.Args, p.HasDefault))
+		}
+	}
+	assert.Equal(t, []string{"@a int[] true", "@x nvarchar[10] false", "@y int[] false"}, names)
+}
+
+func TestCreateReturns(t *testing.T) {
+	doc := ParseString("test.sql", `
+create function [code].Scalar(@x int) returns decimal(18, 2) with schemabinding as begin return 1 end
+go
+create function [code].Inline() returns table as return select 1 as x
+go
+create function [code].Multi(@x int)
+returns @result table (
+    id int, -- the ID
+    name nvarchar(100)
+)
+as begin return end
+go
+create procedure [code].P as select 1
+`)
+	require.Empty(t, doc.Errors)
+	var returns []string
+	for _, c := range doc.Creates {
+		returns = append(returns, c.Returns())
+	}
+	assert.Equal(t, []string{"decimal(18, 2)", "table", "@result table (id int, name nvarchar(100))", ""}, returns)
+}
@@ -0,0 +1,71 @@
+package sqlparser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ResultSet is the shape of the first result set of a procedure, declared in
+// its docstring:
+//
+//	--! resultset:
+//	--!   - {name: OrderID, type: bigint}
+//	--!   - {name: Comment, type: nvarchar(200), nullable: true}
+//	create procedure [code].ListOrders ...
+//
+// Columns are not nullable unless declared so.
+type ResultSet struct {
+	Pos     Pos // position of the `--! resultset:` line
+	Columns []ResultSetColumn
+}
+
+type ResultSetColumn struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"` // as in sp_describe_first_result_set's system_type_name, e.g. `nvarchar(200)`
+	Nullable bool   `yaml:"nullable"`
+}
+
+// ResultSet returns the result set declared in the docstring; nil if there is none
+func (c Create) ResultSet() (*ResultSet, error) {
+	var doc struct {
+		ResultSet *[]ResultSetColumn `yaml:"resultset"`
+	}
+	if err := c.ParseYamlInDocstring(&doc); err != nil {
+		return nil, err
+	}
+	if doc.ResultSet == nil {
+		return nil, nil
+	}
+
+	result := ResultSet{Pos: c.QuotedName.Pos, Columns: *doc.ResultSet}
+	for _, line := range c.Docstring {
+		if strings.HasPrefix(line.Value, "--! resultset:") {
+			result.Pos = line.Pos
+		}
+	}
+	if c.CreateType != "procedure" {
+		return nil, Error{result.Pos, "resultset is only supported for procedures"}
+	}
+	seen := make(map[string]bool)
+	for i, col := range result.Columns {
+		switch {
+		case col.Name == "":
+			return nil, Error{result.Pos, fmt.Sprintf("resultset: column %d has no name", i+1)}
+		case col.Type == "":
+			return nil, Error{result.Pos, fmt.Sprintf("resultset: column %s has no type", col.Name)}
+		case seen[strings.ToLower(col.Name)]:
+			return nil, Error{result.Pos, fmt.Sprintf("resultset: column %s is declared twice", col.Name)}
+		}
+		seen[strings.ToLower(col.Name)] = true
+	}
+	return &result, nil
+}
+
+// SameType compares the declared type of the column to a type as named by
+// sp_describe_first_result_set, ignoring case and whitespace
+func (col ResultSetColumn) SameType(systemTypeName string) bool {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.Join(strings.Fields(s), ""))
+	}
+	return normalize(col.Type) == normalize(systemTypeName)
+}
@@ -42,6 +42,12 @@ type Scanner struct {
 
 type TokenType int
 
+// NewScanner returns a Scanner positioned before the first token of input;
+// call NextToken() to start scanning
+func NewScanner(file FileRef, input string) *Scanner {
+	return &Scanner{input: input, file: file}
+}
+
 func (s *Scanner) TokenType() TokenType {
 	return s.tokenType
 }
@@ -22,7 +22,7 @@ func TopologicalSort(input []Create) (output []Create, errpos Pos, err error) {
 	// map of declared name to the index of the SourceFile that declares it...
 	declaredToIdx := make(map[string]int)
 	for i, f := range input {
-		declaredToIdx[f.QuotedName.Value] = i
+		declaredToIdx[f.QualifiedName()] = i
 	}
 
 	var visit func(i int) (Pos, error)
@@ -3,14 +3,19 @@ package sqltest
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"github.com/gofrs/uuid/v5"
-	mssql "github.com/microsoft/go-mssqldb"
-	"github.com/microsoft/go-mssqldb/msdsn"
-	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
+	"testing"
 	"time"
+
+	"github.com/gofrs/uuid/v5"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/msdsn"
+	"github.com/vippsas/sqlcode"
+	"github.com/vippsas/sqlcode/sqlparser"
 )
 
 type StdoutLogger struct {
@@ -26,13 +31,27 @@ func (s StdoutLogger) Println(v ...interface{}) {
 
 var _ mssql.Logger = StdoutLogger{}
 
+// Fixture is a database created for tests in the SQL server pointed to by
+// the SQLSERVER_DSN environment variable
 type Fixture struct {
 	DB      *sql.DB
 	DBName  string
 	adminDB *sql.DB
 }
 
-func NewFixture() *Fixture {
+// NewFixture creates a new, empty database for the test t; it is dropped
+// when the test completes. See also SharedFixture, which is faster.
+func NewFixture(t testing.TB) *Fixture {
+	t.Helper()
+	fixture, err := newFixture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(fixture.Teardown)
+	return fixture
+}
+
+func newFixture() (*Fixture, error) {
 	var fixture Fixture
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
@@ -40,7 +59,7 @@ func NewFixture() *Fixture {
 
 	dsn := os.Getenv("SQLSERVER_DSN")
 	if dsn == "" {
-		panic("Must set SQLSERVER_DSN to run tests")
+		return nil, errors.New("Must set SQLSERVER_DSN to run tests")
 	}
 	dsn = dsn + "&log=3"
 
@@ -50,38 +69,47 @@ func NewFixture() *Fixture {
 
 	fixture.adminDB, err = sql.Open("sqlserver", dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	fixture.DBName = strings.ReplaceAll(uuid.Must(uuid.NewV4()).String(), "-", "")
 
 	_, err = fixture.adminDB.ExecContext(ctx, fmt.Sprintf(`create database [%s]`, fixture.DBName))
 	if err != nil {
-		panic(err)
+		_ = fixture.adminDB.Close()
+		return nil, err
+	}
+	// From here on, Teardown is needed to clean up
+	fail := func(err error) (*Fixture, error) {
+		fixture.Teardown()
+		return nil, err
 	}
+
 	// These settings are just to get "worst-case" for our tests, since snapshot could interfer
 	_, err = fixture.adminDB.ExecContext(ctx, fmt.Sprintf(`alter database [%s] set allow_snapshot_isolation on`, fixture.DBName))
 	if err != nil {
-		panic(err)
+		return fail(err)
 	}
 	_, err = fixture.adminDB.ExecContext(ctx, fmt.Sprintf(`alter database [%s] set read_committed_snapshot on`, fixture.DBName))
 	if err != nil {
-		panic(err)
+		return fail(err)
 	}
 
 	pdsn, err := msdsn.Parse(dsn)
 	if err != nil {
-		panic(err)
+		return fail(err)
 	}
 	pdsn.Database = fixture.DBName
 
 	fixture.DB, err = sql.Open("sqlserver", pdsn.URL().String())
 	if err != nil {
-		panic(err)
+		return fail(err)
 	}
 
-	return &fixture
+	return &fixture, nil
 }
 
+// Teardown drops the database. It is called automatically when the test
+// of NewFixture completes, but it is safe to call more than once.
 func (f *Fixture) Teardown() {
 	if f.adminDB == nil {
 		return
@@ -90,39 +118,162 @@ func (f *Fixture) Teardown() {
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 
-	_ = f.DB.Close()
-	f.DB = nil
+	if f.DB != nil {
+		_ = f.DB.Close()
+		f.DB = nil
+	}
 	_, _ = f.adminDB.ExecContext(ctx, fmt.Sprintf(`drop database [%s]`, f.DBName))
 	_ = f.adminDB.Close()
 	f.adminDB = nil
 }
 
-func (f *Fixture) RunMigrations() {
-	migrationSql, err := ioutil.ReadFile("migrations/from0001/0001.changefeed.sql")
+// WithTx runs fn in a transaction that is always rolled back afterwards; this
+// isolates tests sharing a database (see SharedFixture) from each other,
+// also when they use t.Parallel()
+func (f *Fixture) WithTx(t testing.TB, fn func(tx *sql.Tx)) {
+	t.Helper()
+	tx, err := f.DB.BeginTx(context.Background(), nil)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	parts := strings.Split(string(migrationSql), "\ngo\n")
-	for _, p := range parts {
-		_, err = f.DB.Exec(p)
-		if err != nil {
-			fmt.Println(p)
-			panic(err)
-		}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	fn(tx)
+}
+
+// RunMigrations installs the sqlcode SQL library (see migrations/) in the database
+func (f *Fixture) RunMigrations(t testing.TB) {
+	t.Helper()
+	if err := f.runMigrations(); err != nil {
+		t.Fatal(err)
 	}
 }
 
-func (f *Fixture) RunMigrationFile(filename string) {
-	migrationSql, err := ioutil.ReadFile(filename)
+func (f *Fixture) runMigrations() error {
+	return sqlcode.InstallOrUpgrade(context.Background(), f.DB)
+}
+
+// RunMigrationFile executes the batches of a SQL file in the database
+func (f *Fixture) RunMigrationFile(t testing.TB, filename string) {
+	t.Helper()
+	migrationSql, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches, err := sqlparser.SplitBatches(sqlparser.FileRef(filename), string(migrationSql))
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	parts := strings.Split(string(migrationSql), "\ngo\n")
-	for _, p := range parts {
-		_, err = f.DB.Exec(p)
+	for _, batch := range batches {
+		_, err = f.DB.Exec(batch)
 		if err != nil {
-			fmt.Println(p)
-			panic(err)
+			t.Fatalf("%s: %s\n\n%s", filename, err, batch)
 		}
 	}
 }
+
+var shared struct {
+	sync.Mutex
+	fixture *Fixture
+	err     error
+
+	// kept is set by RunShared, which drops the database after all tests;
+	// otherwise it is dropped when the last test using it completes
+	kept  bool
+	users int
+}
+
+// SharedFixture returns a database shared by all tests in the package,
+// with the sqlcode SQL library installed and the deployables uploaded
+// (each only once). Use WithTx to isolate tests from each other.
+//
+// The database is created by the first call. To keep it for all the tests
+// of the package, run them with Main (or RunShared) from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		sqltest.Main(m)
+//	}
+//
+// Without it, the database is dropped (using t.Cleanup) when no test using
+// it is running any more, so it is only shared by tests running at the same
+// time, such as those using t.Parallel().
+func SharedFixture(t testing.TB, deployables ...*sqlcode.Deployable) *Fixture {
+	t.Helper()
+	shared.Lock()
+	defer shared.Unlock()
+
+	if shared.fixture == nil && shared.err == nil {
+		shared.fixture, shared.err = newFixture()
+		if shared.err == nil {
+			shared.err = shared.fixture.runMigrations()
+			if shared.err != nil {
+				shared.fixture.Teardown()
+				shared.fixture = nil
+			}
+		}
+	}
+	if shared.err != nil {
+		t.Fatal(shared.err)
+	}
+	if !shared.kept {
+		shared.users++
+		t.Cleanup(releaseShared)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	for _, d := range deployables {
+		// EnsureUploaded is a no-op if already done to this DB
+		if err := d.EnsureUploaded(ctx, shared.fixture.DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return shared.fixture
+}
+
+// releaseShared is the cleanup of a test using SharedFixture outside RunShared
+func releaseShared() {
+	shared.Lock()
+	defer shared.Unlock()
+	shared.users--
+	if shared.users == 0 && !shared.kept {
+		teardownShared()
+	}
+}
+
+// TeardownShared drops the database of SharedFixture, if it was created
+func TeardownShared() {
+	shared.Lock()
+	defer shared.Unlock()
+	teardownShared()
+}
+
+func teardownShared() {
+	if shared.fixture != nil {
+		shared.fixture.Teardown()
+	}
+	shared.fixture = nil
+	shared.err = nil
+}
+
+// RunShared runs the tests, keeping the database of SharedFixture until they
+// have all completed, and then drops it; it returns the exit code of m.Run.
+// Call it from TestMain, or use Main.
+func RunShared(m *testing.M) int {
+	shared.Lock()
+	shared.kept = true
+	shared.Unlock()
+	defer func() {
+		shared.Lock()
+		defer shared.Unlock()
+		shared.kept = false
+		teardownShared()
+	}()
+	return m.Run()
+}
+
+// Main runs the tests with RunShared and exits; call it from TestMain
+func Main(m *testing.M) {
+	os.Exit(RunShared(m))
+}
@@ -0,0 +1,37 @@
+package sqltest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedFixtureCleanup(t *testing.T) {
+	// a Fixture without a database, so that no server is needed
+	fake := &Fixture{DBName: "shared"}
+	shared.fixture = fake
+	t.Cleanup(func() {
+		shared.fixture, shared.err, shared.kept, shared.users = nil, nil, false, 0
+	})
+
+	// Without RunShared, the database is dropped when the last test using it completes
+	t.Run("a", func(t *testing.T) {
+		assert.Same(t, fake, SharedFixture(t))
+		t.Run("b", func(t *testing.T) {
+			assert.Same(t, fake, SharedFixture(t))
+		})
+		// still used by a
+		assert.Same(t, fake, shared.fixture)
+	})
+	assert.Nil(t, shared.fixture)
+	assert.Equal(t, 0, shared.users)
+
+	// With RunShared, it is kept until TeardownShared
+	shared.fixture, shared.kept = fake, true
+	t.Run("kept", func(t *testing.T) {
+		assert.Same(t, fake, SharedFixture(t))
+	})
+	assert.Same(t, fake, shared.fixture)
+	TeardownShared()
+	assert.Nil(t, shared.fixture)
+}
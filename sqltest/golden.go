@@ -0,0 +1,209 @@
+package sqltest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/stretchr/testify/assert"
+)
+
+// UpdateGolden makes AssertGolden rewrite the golden files instead of
+// comparing; it can be wired up to a flag of the test package:
+//
+//	func TestMain(m *testing.M) {
+//		flag.BoolVar(&sqltest.UpdateGolden, "update", false, "rewrite golden files")
+//		flag.Parse()
+//		os.Exit(m.Run())
+//	}
+//
+// Setting the environment variable SQLTEST_UPDATE=1 does the same.
+var UpdateGolden bool
+
+func updateGolden() bool {
+	return UpdateGolden || os.Getenv("SQLTEST_UPDATE") == "1"
+}
+
+type GoldenOptions struct {
+	// SortRows sorts the rows after rendering; use it for queries without
+	// an `order by`, where SQL Server does not guarantee the order of rows
+	SortRows bool
+}
+
+// AssertGolden runs the query and compares the result with the file
+// testdata/<name>.golden. Run the tests with SQLTEST_UPDATE=1 (or see
+// UpdateGolden) to write the file.
+//
+// The result is rendered deterministically as text, including the column
+// types, so that the file can be reviewed in diffs.
+func AssertGolden(t testing.TB, dbi CtxQuerier, name string, qry string, args ...interface{}) bool {
+	t.Helper()
+	return AssertGoldenWithOptions(t, dbi, name, GoldenOptions{}, qry, args...)
+}
+
+func AssertGoldenWithOptions(t testing.TB, dbi CtxQuerier, name string, opts GoldenOptions, qry string, args ...interface{}) bool {
+	t.Helper()
+	rows, err := dbi.QueryContext(context.Background(), qry, args...)
+	if err != nil {
+		t.Fatalf("AssertGolden %s: %s\n\n%s", name, err, qry)
+	}
+	rendered, err := renderGoldenRows(rows, opts)
+	if err != nil {
+		t.Fatalf("AssertGolden %s: %s\n\n%s", name, err, qry)
+	}
+	return assertGoldenString(t, name, rendered)
+}
+
+func assertGoldenString(t testing.TB, name string, actual string) bool {
+	t.Helper()
+	filename := filepath.Join("testdata", name+".golden")
+
+	if updateGolden() {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("updated %s", filename)
+		return true
+	}
+
+	expected, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Errorf("golden file %s does not exist; run the tests with SQLTEST_UPDATE=1 to create it", filename)
+		return false
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return assert.Equal(t, string(expected), actual,
+		"result differs from %s; if the change is intended, run the tests with SQLTEST_UPDATE=1", filename)
+}
+
+func renderGoldenRows(rows *sql.Rows, opts GoldenOptions) (string, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return "", err
+	}
+	var typeNames []string
+	for _, ct := range columnTypes {
+		typeNames = append(typeNames, ct.DatabaseTypeName())
+	}
+
+	var result [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return "", err
+		}
+		result = append(result, values)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return renderGolden(columns, typeNames, result, opts), nil
+}
+
+// renderGolden renders a result set as an aligned table; first the column
+// names and types, then one line per row
+func renderGolden(columns []string, typeNames []string, rows [][]interface{}, opts GoldenOptions) string {
+	header := make([]string, len(columns))
+	for i := range columns {
+		header[i] = fmt.Sprintf("%s %s", columns[i], strings.ToLower(typeNames[i]))
+	}
+
+	var lines [][]string
+	for _, row := range rows {
+		line := make([]string, len(row))
+		for i, value := range row {
+			line[i] = renderGoldenValue(typeNames[i], value)
+		}
+		lines = append(lines, line)
+	}
+	if opts.SortRows {
+		sort.SliceStable(lines, func(i, j int) bool {
+			return strings.Join(lines[i], "\x00") < strings.Join(lines[j], "\x00")
+		})
+	}
+
+	widths := make([]int, len(columns))
+	for _, line := range append([][]string{header}, lines...) {
+		for i, cell := range line {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	var out strings.Builder
+	writeLine := func(cells []string) {
+		var padded []string
+		for i, cell := range cells {
+			padded = append(padded, cell+strings.Repeat(" ", widths[i]-len(cell)))
+		}
+		out.WriteString(strings.TrimRight(strings.Join(padded, " | "), " "))
+		out.WriteString("\n")
+	}
+	writeLine(header)
+	var separator []string
+	for _, w := range widths {
+		separator = append(separator, strings.Repeat("-", w))
+	}
+	out.WriteString(strings.Join(separator, "-+-") + "\n")
+	for _, line := range lines {
+		writeLine(line)
+	}
+	fmt.Fprintf(&out, "(%d rows)\n", len(lines))
+	return out.String()
+}
+
+// keep each row on a single line
+var goldenStringEscaper = strings.NewReplacer("'", "''", "\n", `\n`, "\r", `\r`)
+
+func renderGoldenValue(typeName string, value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		switch strings.ToUpper(typeName) {
+		case "UNIQUEIDENTIFIER":
+			var u mssql.UniqueIdentifier
+			if err := u.Scan(v); err == nil {
+				return u.String()
+			}
+		case "DECIMAL", "MONEY", "SMALLMONEY":
+			return string(v)
+		}
+		return "0x" + strings.ToUpper(hex.EncodeToString(v))
+	case string:
+		return "'" + goldenStringEscaper.Replace(v) + "'"
+	case time.Time:
+		return v.Format("2006-01-02T15:04:05.9999999Z07:00")
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
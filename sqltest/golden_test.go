@@ -0,0 +1,32 @@
+package sqltest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderGolden(t *testing.T) {
+	rows := [][]interface{}{
+		{int64(2), "it's\nmultiline", []byte("1.50"), nil,
+			[]byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x78, 0x56, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0},
+			time.Date(2024, 1, 2, 3, 4, 5, 600000000, time.FixedZone("", 3600))},
+		{int64(1), "short", []byte("10.00"), true, nil, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	rendered := renderGolden(
+		[]string{"id", "name", "amount", "flag", "guid", "at"},
+		[]string{"BIGINT", "NVARCHAR", "DECIMAL", "BIT", "UNIQUEIDENTIFIER", "DATETIMEOFFSET"},
+		rows, GoldenOptions{SortRows: true})
+	assertGoldenString(t, "render", rendered)
+
+	unsorted := renderGolden([]string{"x"}, []string{"INT"}, [][]interface{}{{int64(2)}, {int64(1)}}, GoldenOptions{})
+	assert.Equal(t, "x int\n-----\n2\n1\n(2 rows)\n", unsorted)
+}
+
+func TestUpdateGolden(t *testing.T) {
+	t.Setenv("SQLTEST_UPDATE", "")
+	assert.False(t, updateGolden())
+	t.Setenv("SQLTEST_UPDATE", "1")
+	assert.True(t, updateGolden())
+}
@@ -0,0 +1,184 @@
+package sqltest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/vippsas/sqlcode"
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+// SQLTestResult is the outcome of running a single test procedure
+type SQLTestResult struct {
+	Name     string // QualifiedName() of the procedure
+	Pos      sqlparser.Pos
+	Duration time.Duration
+	Err      error // nil if the test passed
+}
+
+type SQLTestResults []SQLTestResult
+
+func (r SQLTestResults) Failed() (n int) {
+	for _, result := range r {
+		if result.Err != nil {
+			n++
+		}
+	}
+	return
+}
+
+// RunSQLTests uploads d to a throwaway schema suffix, runs all the test
+// procedures in it (see sqlparser.Create.IsTestProcedure) and then drops the
+// schema again. d should not have been included with Options.ExcludeTests.
+//
+// Each test is run in its own transaction which is rolled back afterwards. A test
+// passes if it does not raise an error; see the assertion procedures installed
+// by migrations/0004.sqlcode.sql. err is only returned if the tests could not
+// be run at all; failing tests are reported in results.
+func RunSQLTests(ctx context.Context, dbc sqlcode.DB, d sqlcode.Deployable) (results SQLTestResults, err error) {
+	var random [4]byte
+	if _, err = rand.Read(random[:]); err != nil {
+		return
+	}
+	d = d.WithSchemaSuffix("test-" + hex.EncodeToString(random[:]))
+
+	if err = d.Upload(ctx, dbc); err != nil {
+		return
+	}
+	defer func() {
+		dropErr := d.Drop(context.Background(), dbc)
+		if err == nil {
+			err = dropErr
+		}
+	}()
+
+	for _, c := range d.CodeBase.Creates {
+		if !c.IsTestProcedure() {
+			continue
+		}
+		result := SQLTestResult{Name: c.QualifiedName(), Pos: c.QuotedName.Pos}
+
+		tx, err := dbc.BeginTx(ctx, nil)
+		if err != nil {
+			return results, err
+		}
+		start := time.Now()
+		_, result.Err = tx.ExecContext(ctx, d.Patch(procedureReference(c)))
+		result.Duration = time.Since(start)
+		// the test may have ended the transaction itself
+		_ = tx.Rollback()
+
+		if result.Err != nil {
+			result.Err = d.ResolveError(result.Err)
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+// procedureReference is how c is referred to in SQL before Patch, e.g. [code].[test:foo]
+func procedureReference(c sqlparser.Create) string {
+	namespace := c.Namespace
+	if namespace == "" {
+		namespace = "code"
+	}
+	return "[" + namespace + "]." + c.QuotedName.Value
+}
+
+// WriteGoTest writes the results in the format of `go test -v`, so that tools
+// consuming that output can be used
+func (r SQLTestResults) WriteGoTest(w io.Writer) error {
+	for _, result := range r {
+		status := "PASS"
+		if result.Err != nil {
+			status = "FAIL"
+		}
+		if _, err := fmt.Fprintf(w, "=== RUN   %s\n--- %s: %s (%.2fs)\n", result.Name, status, result.Name, result.Duration.Seconds()); err != nil {
+			return err
+		}
+		if result.Err != nil {
+			if _, err := fmt.Fprintf(w, "    %s:%d:%d: %s\n", result.Pos.File, result.Pos.Line, result.Pos.Col,
+				strings.ReplaceAll(result.Err.Error(), "\n", "\n        ")); err != nil {
+				return err
+			}
+		}
+	}
+	status := "PASS"
+	if r.Failed() > 0 {
+		status = "FAIL"
+	}
+	_, err := fmt.Fprintln(w, status)
+	return err
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the results as JUnit XML, for CI systems
+func (r SQLTestResults) WriteJUnit(w io.Writer, suiteName string) error {
+	suite := junitTestSuite{
+		Name:     suiteName,
+		Tests:    len(r),
+		Failures: r.Failed(),
+	}
+	var total time.Duration
+	for _, result := range r {
+		total += result.Duration
+		testCase := junitTestCase{
+			Name:      result.Name,
+			Classname: suiteName,
+			File:      string(result.Pos.File),
+			Line:      result.Pos.Line,
+			Time:      fmt.Sprintf("%.3f", result.Duration.Seconds()),
+		}
+		if result.Err != nil {
+			testCase.Failure = &junitFailure{
+				Message: result.Err.Error(),
+				Text:    fmt.Sprintf("%s:%d:%d: %s", result.Pos.File, result.Pos.Line, result.Pos.Col, result.Err.Error()),
+			}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
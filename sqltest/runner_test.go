@@ -0,0 +1,45 @@
+package sqltest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+var testResults = SQLTestResults{
+	{Name: "[test:Foo]", Pos: sqlparser.Pos{File: "foo.sql", Line: 1, Col: 18}, Duration: 10 * time.Millisecond},
+	{Name: "[test:Bar]", Pos: sqlparser.Pos{File: "bar.sql", Line: 5, Col: 18}, Duration: 20 * time.Millisecond,
+		Err: errors.New("AssertEquals failed: expected 1, got 2")},
+}
+
+func TestWriteGoTest(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testResults.WriteGoTest(&buf))
+	assert.Equal(t, `=== RUN   [test:Foo]
+--- PASS: [test:Foo] (0.01s)
+=== RUN   [test:Bar]
+--- FAIL: [test:Bar] (0.02s)
+    bar.sql:5:18: AssertEquals failed: expected 1, got 2
+FAIL
+`, buf.String())
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testResults.WriteJUnit(&buf, "myservice"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="myservice" tests="2" failures="1" time="0.030">
+    <testcase name="[test:Foo]" classname="myservice" file="foo.sql" line="1" time="0.010"></testcase>
+    <testcase name="[test:Bar]" classname="myservice" file="bar.sql" line="5" time="0.020">
+      <failure message="AssertEquals failed: expected 1, got 2">bar.sql:5:18: AssertEquals failed: expected 1, got 2</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, buf.String())
+}
@@ -9,9 +9,8 @@ import (
 )
 
 func Test_RowsAffected(t *testing.T) {
-	fixture := NewFixture()
-	defer fixture.Teardown()
-	fixture.RunMigrationFile("../migrations/0001.sqlcode.sql")
+	fixture := NewFixture(t)
+	fixture.RunMigrations(t)
 
 	ctx := context.Background()
 
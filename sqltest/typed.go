@@ -0,0 +1,207 @@
+package sqltest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/vippsas/sqlcode"
+)
+
+// QueryStructs runs a query and returns a T for each row. T must be a
+// struct; each column is stored in the field with a matching `db:"..."` tag,
+// or else a field with the same name (case-insensitive). It is an error
+// if a column has no matching field.
+//
+// Scanning is done by database/sql, so fields can be of any type that a
+// column can be scanned into (including sql.Scanner implementations, and pointers
+// for nullable columns). In addition, uniqueidentifier columns can be scanned into
+// strings (and *string or sql.Null[string]), and decimal columns into floats.
+func QueryStructs[T any](dbi CtxQuerier, qry string, args ...interface{}) (result []T, err error) {
+	rows, err := dbi.QueryContext(context.Background(), qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	var zero T
+	fieldIndexes, err := structFieldIndexes(reflect.TypeOf(zero), columnTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var item T
+		v := reflect.ValueOf(&item).Elem()
+		dest := make([]interface{}, len(columnTypes))
+		for i, ct := range columnTypes {
+			dest[i] = scanDest(v.FieldByIndex(fieldIndexes[i]).Addr().Interface(), ct.DatabaseTypeName())
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+// QueryOne is QueryStructs for queries returning exactly one row
+func QueryOne[T any](dbi CtxQuerier, qry string, args ...interface{}) (result T, err error) {
+	items, err := QueryStructs[T](dbi, qry, args...)
+	if err != nil {
+		return
+	}
+	if len(items) != 1 {
+		err = fmt.Errorf("QueryOne: expected 1 row, got %d", len(items))
+		return
+	}
+	return items[0], nil
+}
+
+// QueryScalar runs a query returning a single row with a single column, and
+// returns the value. The same conversions as for QueryStructs are done.
+func QueryScalar[T any](dbi CtxQuerier, qry string, args ...interface{}) (result T, err error) {
+	rows, err := dbi.QueryContext(context.Background(), qry, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return
+	}
+	if len(columnTypes) != 1 {
+		err = fmt.Errorf("QueryScalar: expected 1 column, got %d", len(columnTypes))
+		return
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return
+	}
+	if err = rows.Scan(scanDest(&result, columnTypes[0].DatabaseTypeName())); err != nil {
+		return
+	}
+	if rows.Next() {
+		err = errors.New("QueryScalar: expected 1 row, got more")
+		return
+	}
+	err = rows.Err()
+	return
+}
+
+// TVP makes a table-valued parameter for a type declared in the SQL code of d,
+// e.g. TVP(SQL, "[code].MyType", []MyTypeRow{...})
+func TVP(d sqlcode.Deployable, typeName string, rows interface{}) mssql.TVP {
+	return mssql.TVP{
+		TypeName: d.Patch(typeName),
+		Value:    rows,
+	}
+}
+
+func structFieldIndexes(t reflect.Type, columnTypes []*sql.ColumnType) ([][]int, error) {
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("QueryStructs: %v is not a struct", t)
+	}
+	byName := make(map[string][]int)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name := f.Tag.Get("db")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		byName[strings.ToLower(name)] = f.Index
+	}
+
+	var result [][]int
+	for _, ct := range columnTypes {
+		index, ok := byName[strings.ToLower(ct.Name())]
+		if !ok {
+			return nil, fmt.Errorf("QueryStructs: no field in %v for column %s", t, ct.Name())
+		}
+		result = append(result, index)
+	}
+	return result, nil
+}
+
+// scanDest wraps the destination of a Scan where database/sql would not
+// do the right thing by itself
+func scanDest(dest interface{}, databaseTypeName string) interface{} {
+	if strings.ToUpper(databaseTypeName) != "UNIQUEIDENTIFIER" {
+		return dest
+	}
+	switch d := dest.(type) {
+	case *string:
+		return uniqueIdentifierString{d}
+	case **string:
+		return nullUniqueIdentifierString{d}
+	case *sql.Null[string]:
+		return sqlNullUniqueIdentifierString{d}
+	}
+	return dest
+}
+
+// uniqueIdentifierString scans a uniqueidentifier into its string form; the driver
+// returns the bytes in SQL Server's mixed-endian order
+type uniqueIdentifierString struct {
+	dest *string
+}
+
+func (u uniqueIdentifierString) Scan(src interface{}) error {
+	if src == nil {
+		return errors.New("NULL uniqueidentifier can not be stored in a string; use *string")
+	}
+	var id mssql.UniqueIdentifier
+	if err := id.Scan(src); err != nil {
+		return err
+	}
+	*u.dest = id.String()
+	return nil
+}
+
+type nullUniqueIdentifierString struct {
+	dest **string
+}
+
+func (u nullUniqueIdentifierString) Scan(src interface{}) error {
+	if src == nil {
+		*u.dest = nil
+		return nil
+	}
+	var s string
+	if err := (uniqueIdentifierString{&s}).Scan(src); err != nil {
+		return err
+	}
+	*u.dest = &s
+	return nil
+}
+
+// sqlNullUniqueIdentifierString is nullUniqueIdentifierString for
+// sql.Null[string], as generated by sqlcode gostructs
+type sqlNullUniqueIdentifierString struct {
+	dest *sql.Null[string]
+}
+
+func (u sqlNullUniqueIdentifierString) Scan(src interface{}) error {
+	if src == nil {
+		*u.dest = sql.Null[string]{}
+		return nil
+	}
+	u.dest.Valid = true
+	return (uniqueIdentifierString{&u.dest.V}).Scan(src)
+}
@@ -0,0 +1,150 @@
+package sqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode"
+)
+
+// cannedResult is returned by cannedDriver for the query with the same text
+type cannedResult struct {
+	columns []string
+	types   []string
+	rows    [][]driver.Value
+}
+
+type cannedDriver map[string]cannedResult
+
+func (d cannedDriver) Open(string) (driver.Conn, error) { return cannedConn{d}, nil }
+
+// cannedDriver is also its own driver.Connector, so that no driver has to be
+// registered globally with sql.Register
+func (d cannedDriver) Connect(context.Context) (driver.Conn, error) { return cannedConn{d}, nil }
+func (d cannedDriver) Driver() driver.Driver                        { return d }
+
+type cannedConn struct{ results cannedDriver }
+
+func (c cannedConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c cannedConn) Close() error                        { return nil }
+func (c cannedConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+func (c cannedConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	result := c.results[query]
+	return &cannedRows{cannedResult: result}, nil
+}
+
+type cannedRows struct {
+	cannedResult
+	next int
+}
+
+func (r *cannedRows) Columns() []string                       { return r.columns }
+func (r *cannedRows) Close() error                            { return nil }
+func (r *cannedRows) ColumnTypeDatabaseTypeName(i int) string { return r.types[i] }
+func (r *cannedRows) Next(dest []driver.Value) error {
+	if r.next == len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+func openCanned(t *testing.T, results cannedDriver) *sql.DB {
+	dbi := sql.OpenDB(results)
+	t.Cleanup(func() { _ = dbi.Close() })
+	return dbi
+}
+
+var guidBytes = []byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x78, 0x56, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}
+
+func TestQueryStructs(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+	dbi := openCanned(t, cannedDriver{
+		"select": {
+			columns: []string{"id", "Name", "amount", "ExternalID", "at", "ParentID"},
+			types:   []string{"BIGINT", "NVARCHAR", "DECIMAL", "UNIQUEIDENTIFIER", "DATETIMEOFFSET", "UNIQUEIDENTIFIER"},
+			rows: [][]driver.Value{
+				{int64(1), "one", []byte("1.50"), guidBytes, at, nil},
+				{int64(2), "two", []byte("2.00"), guidBytes, at, guidBytes},
+			},
+		},
+		"scalar": {columns: []string{""}, types: []string{"UNIQUEIDENTIFIER"}, rows: [][]driver.Value{{guidBytes}}},
+		"empty":  {columns: []string{"id"}, types: []string{"INT"}},
+	})
+
+	type item struct {
+		ID         int `db:"id"`
+		Name       string
+		Amount     float64
+		ExternalID string
+		At         time.Time
+		ParentID   *string
+	}
+	items, err := QueryStructs[item](dbi, "select")
+	require.NoError(t, err)
+	parent := "12345678-1234-5678-1234-56789ABCDEF0"
+	assert.Equal(t, []item{
+		{ID: 1, Name: "one", Amount: 1.5, ExternalID: parent, At: at},
+		{ID: 2, Name: "two", Amount: 2, ExternalID: parent, At: at, ParentID: &parent},
+	}, items)
+
+	_, err = QueryOne[item](dbi, "select")
+	assert.EqualError(t, err, "QueryOne: expected 1 row, got 2")
+
+	_, err = QueryStructs[struct{ ID int }](dbi, "select")
+	assert.EqualError(t, err, "QueryStructs: no field in struct { ID int } for column Name")
+
+	guid, err := QueryScalar[string](dbi, "scalar")
+	require.NoError(t, err)
+	assert.Equal(t, parent, guid)
+
+	_, err = QueryScalar[int](dbi, "empty")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+// resultSetSQL declares the result set ListOrdersRow in resultsets_gen_test.go
+// is generated from
+const resultSetSQL = `--! resultset:
+--!   - {name: order_id, type: bigint}
+--!   - {name: Amount, type: decimal(18,2)}
+--!   - {name: ExternalID, type: uniqueidentifier, nullable: true}
+--!   - {name: CreatedAt, type: datetimeoffset(7)}
+create procedure [code].ListOrders as select 1
+`
+
+func TestQueryStructsGenerated(t *testing.T) {
+	d, err := sqlcode.Include(sqlcode.Options{}, fstest.MapFS{"orders.sql": &fstest.MapFile{Data: []byte(resultSetSQL)}})
+	require.NoError(t, err)
+	src, err := d.GoResultSetStructs("sqltest")
+	require.NoError(t, err)
+	generated, err := os.ReadFile("resultsets_gen_test.go")
+	require.NoError(t, err)
+	require.Equal(t, string(src), string(generated), "resultsets_gen_test.go is out of date")
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+	dbi := openCanned(t, cannedDriver{
+		"exec": {
+			columns: []string{"order_id", "Amount", "ExternalID", "CreatedAt"},
+			types:   []string{"BIGINT", "DECIMAL", "UNIQUEIDENTIFIER", "DATETIMEOFFSET"},
+			rows: [][]driver.Value{
+				{int64(1), []byte("1.50"), guidBytes, at},
+				{int64(2), []byte("2.00"), nil, at},
+			},
+		},
+	})
+	rows, err := QueryStructs[ListOrdersRow](dbi, "exec")
+	require.NoError(t, err)
+	assert.Equal(t, []ListOrdersRow{
+		{OrderId: 1, Amount: "1.50", ExternalID: sql.Null[string]{V: "12345678-1234-5678-1234-56789ABCDEF0", Valid: true}, CreatedAt: at},
+		{OrderId: 2, Amount: "2.00", CreatedAt: at},
+	}, rows)
+}
@@ -0,0 +1,274 @@
+package sqlcode
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// UninstallOptions are the options for Uninstall
+type UninstallOptions struct {
+	// DryRun only returns the statements that would be executed
+	DryRun bool
+
+	// Force uninstalls even if some code schemas are in use
+	Force bool
+
+	// RecentlyUsed is how long ago code in a schema must have been executed
+	// last for the schema to not be considered in use; the default is 24 hours,
+	// and a negative value turns the check off
+	RecentlyUsed time.Duration
+}
+
+// SchemaUsage is what Uninstall knows about the use of a code schema
+type SchemaUsage struct {
+	Name     string
+	Locked   bool      // someone holds the lock of EnsureUploaded on it
+	LastUsed time.Time // last execution of any of its objects; zero if never executed or not known
+	// UsageUnknown is set when the execution statistics could not be read
+	// for lack of permissions (see Uninstall)
+	UsageUnknown bool
+}
+
+// SchemasInUseError is returned by Uninstall when code schemas are in use
+type SchemasInUseError struct {
+	Schemas []SchemaUsage
+}
+
+func (e SchemasInUseError) Error() string {
+	var msg strings.Builder
+	msg.WriteString("refusing to uninstall sqlcode while code schemas are in use (use Force to override):\n")
+	for _, s := range e.Schemas {
+		msg.WriteString("\n- [" + s.Name + "]")
+		if s.Locked {
+			msg.WriteString(" is locked")
+		}
+		if !s.LastUsed.IsZero() {
+			if s.Locked {
+				msg.WriteString(" and")
+			}
+			msg.WriteString(" was used at " + s.LastUsed.UTC().Format(time.RFC3339))
+		}
+		if s.UsageUnknown {
+			if s.Locked {
+				msg.WriteString(" and")
+			}
+			msg.WriteString(" may be in use; reading when it was last used needs VIEW SERVER STATE (VIEW DATABASE STATE on Azure SQL Database)")
+		}
+	}
+	return msg.String()
+}
+
+// Uninstall removes the sqlcode library and everything created with it
+// from the database: all code schemas, the sqlcode schema, the certificates
+// signing its procedures (and their users), and the sqlcode roles and users.
+// Members are removed from the roles first. Everything is done in a single
+// transaction, and the statements executed are returned. Needs db_owner or
+// equivalent permissions.
+//
+// Unless opts.Force is set, a SchemasInUseError is returned if any code
+// schema is locked by EnsureUploaded or has been used recently. When code
+// was last used is read from sys.dm_exec_procedure_stats and
+// sys.dm_exec_function_stats, which need VIEW SERVER STATE (VIEW DATABASE
+// STATE on Azure SQL Database); without it, every code schema is considered
+// in use. With opts.DryRun, the statements are returned together with the
+// SchemasInUseError.
+func Uninstall(ctx context.Context, dbc DB, opts UninstallOptions) (statements []string, err error) {
+	usage, err := codeSchemaUsage(ctx, dbc)
+	if err != nil {
+		return nil, err
+	}
+	var inUseErr error
+	if !opts.Force {
+		recently := opts.RecentlyUsed
+		if recently == 0 {
+			recently = 24 * time.Hour
+		}
+		var inUse []SchemaUsage
+		for _, s := range usage {
+			recentlyUsed := recently > 0 && (s.UsageUnknown || !s.LastUsed.IsZero() && time.Since(s.LastUsed) < recently)
+			if s.Locked || recentlyUsed {
+				inUse = append(inUse, s)
+			}
+		}
+		if len(inUse) > 0 {
+			inUseErr = SchemasInUseError{Schemas: inUse}
+			if !opts.DryRun {
+				return nil, inUseErr
+			}
+		}
+	}
+
+	// synonyms made by Promote have to be dropped before the schemas
+	statements, err = queryStatements(ctx, dbc, `
+select concat('drop synonym ', quotename(s.name), '.', quotename(o.name))
+from sys.synonyms o
+join sys.schemas s on s.schema_id = o.schema_id
+where s.principal_id = user_id('sqlcode-user-with-no-permissions')
+order by s.name, o.name
+`)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range usage {
+		namespace, suffix, _ := strings.Cut(s.Name, "@")
+		stmt := "exec sqlcode.DropCodeSchema @schemasuffix = " + quoteString(suffix)
+		if namespace != "code" {
+			stmt += ", @namespace = " + quoteString(namespace)
+		}
+		statements = append(statements, stmt)
+	}
+
+	more, err := uninstallStatements(ctx, dbc)
+	if err != nil {
+		return nil, err
+	}
+	statements = append(statements, more...)
+
+	if opts.DryRun {
+		return statements, inUseErr
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			_ = tx.Rollback()
+			return nil, fmt.Errorf("while executing `%s`: %w", stmt, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
+
+// codeSchemaUsage lists the schemas created by sqlcode.CreateCodeSchema,
+// which are owned by [sqlcode-user-with-no-permissions]. UsageUnknown is set
+// if the execution statistics can not be read for lack of permissions.
+func codeSchemaUsage(ctx context.Context, dbc DB) (result []SchemaUsage, err error) {
+	result, err = queryCodeSchemaUsage(ctx, dbc, true)
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) && (sqlErr.Number == 297 || sqlErr.Number == 300) {
+		return queryCodeSchemaUsage(ctx, dbc, false)
+	}
+	return result, err
+}
+
+func queryCodeSchemaUsage(ctx context.Context, dbc DB, withStats bool) (result []SchemaUsage, err error) {
+	lastUsed := `cast(null as datetime)`
+	if withStats {
+		lastUsed = `(
+    select max(x.last_execution_time)
+    from (
+        select object_id, last_execution_time from sys.dm_exec_procedure_stats where database_id = db_id()
+        union all
+        select object_id, last_execution_time from sys.dm_exec_function_stats where database_id = db_id()
+    ) x
+    join sys.objects o on o.object_id = x.object_id
+    where o.schema_id = s.schema_id
+)`
+	}
+	rows, err := dbc.QueryContext(ctx, `
+select
+    s.name,
+    cast(iif(applock_test('public', concat('sqlcode.EnsureUploaded/', substring(s.name, charindex('@', s.name) + 1, 128)), 'Exclusive', 'Session') = 0, 1, 0) as bit),
+    `+lastUsed+`
+from sys.schemas s
+where s.principal_id = user_id('sqlcode-user-with-no-permissions') and s.name like '%@%'
+order by s.name
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s SchemaUsage
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&s.Name, &s.Locked, &lastUsed); err != nil {
+			return nil, err
+		}
+		s.LastUsed = lastUsed.Time
+		s.UsageUnknown = !withStats
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// uninstallStatements returns the statements removing the sqlcode schema,
+// certificates, roles and users that exist, in the order they can be dropped
+func uninstallStatements(ctx context.Context, dbc DB) (statements []string, err error) {
+	queries := []string{
+		// objects in the sqlcode schema; procedures first, as they are signed
+		// by the certificates and may be referenced by other objects
+		`
+select concat('drop ', case o.type when 'P' then 'procedure' when 'U' then 'table' when 'V' then 'view' else 'function' end,
+    ' sqlcode.', quotename(o.name))
+from sys.objects o
+where o.schema_id = schema_id('sqlcode') and o.parent_object_id = 0 and o.type in ('P', 'U', 'V', 'FN', 'IF', 'TF')
+order by iif(o.type = 'P', 0, 1), o.name
+`,
+		`select 'drop schema sqlcode' where schema_id('sqlcode') is not null`,
+		// the certificates signing the procedures, and the users made from them
+		`
+select s.stmt
+from sys.certificates c
+left join sys.database_principals p on p.sid = c.sid
+cross apply (values
+    (1, iif(p.name is null, null, concat('alter role db_owner drop member ', quotename(p.name)))),
+    (2, iif(p.name is null, null, concat('drop user ', quotename(p.name)))),
+    (3, concat('drop certificate ', quotename(c.name)))
+) s(n, stmt)
+where c.name like 'cert/sqlcode%' and s.stmt is not null
+order by c.name, s.n
+`,
+		// members of the sqlcode roles
+		`
+select concat('alter role ', quotename(r.name), ' drop member ', quotename(m.name))
+from sys.database_role_members rm
+join sys.database_principals r on r.principal_id = rm.role_principal_id
+join sys.database_principals m on m.principal_id = rm.member_principal_id
+where r.name in ('sqlcode-deploy-role', 'sqlcode-execute-role')
+order by r.name, m.name
+`,
+		// the sqlcode users, and then roles
+		`
+select concat('drop ', iif(p.type = 'R', 'role', 'user'), ' ', quotename(p.name))
+from sys.database_principals p
+where p.name in ('sqlcode-deploy-sandbox-user', 'sqlcode-user-with-no-permissions', 'sqlcode-deploy-role', 'sqlcode-execute-role')
+order by iif(p.type = 'R', 1, 0), p.name
+`,
+	}
+	for _, qry := range queries {
+		more, err := queryStatements(ctx, dbc, qry)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, more...)
+	}
+	return statements, nil
+}
+
+// queryStatements runs a query returning a statement in each row
+func queryStatements(ctx context.Context, dbc DB, qry string) (statements []string, err error) {
+	rows, err := dbc.QueryContext(ctx, qry)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			return nil, err
+		}
+		statements = append(statements, stmt)
+	}
+	return statements, rows.Err()
+}
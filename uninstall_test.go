@@ -0,0 +1,97 @@
+package sqlcode
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlcodetest"
+)
+
+func TestUninstall(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	fake.CreateSchema("code@old")
+	fake.CreateSchema("billing@old")
+
+	recent := time.Now().Add(-time.Hour)
+	fake.Respond("applock_test", []string{"name", "locked", "lastused"},
+		[]interface{}{"billing@old", false, nil},
+		[]interface{}{"code@old", true, recent},
+	)
+	fake.Respond("order by iif(o.type = 'P', 0, 1)", []string{""},
+		[]interface{}{"drop procedure sqlcode.[CreateCodeSchema]"},
+		[]interface{}{"drop table sqlcode.[Version]"},
+	)
+	fake.Respond("select 'drop schema sqlcode'", []string{""}, []interface{}{"drop schema sqlcode"})
+	fake.Respond("from sys.certificates", []string{""},
+		[]interface{}{"alter role db_owner drop member [certuser/sqlcode2]"},
+		[]interface{}{"drop user [certuser/sqlcode2]"},
+		[]interface{}{"drop certificate [cert/sqlcode2]"},
+	)
+	fake.Respond("from sys.database_role_members", []string{""},
+		[]interface{}{"alter role [sqlcode-deploy-role] drop member [sqlcode-deploy-sandbox-user]"},
+	)
+	fake.Respond("where p.name in", []string{""},
+		[]interface{}{"drop user [sqlcode-deploy-sandbox-user]"},
+		[]interface{}{"drop role [sqlcode-deploy-role]"},
+	)
+
+	statements, err := Uninstall(ctx, dbc, UninstallOptions{})
+	var inUse SchemasInUseError
+	require.True(t, errors.As(err, &inUse))
+	assert.Nil(t, statements)
+	assert.Equal(t, "refusing to uninstall sqlcode while code schemas are in use (use Force to override):\n\n- [code@old] is locked and was used at "+
+		recent.UTC().Format(time.RFC3339), err.Error())
+
+	expected := []string{
+		"exec sqlcode.DropCodeSchema @schemasuffix = N'old', @namespace = N'billing'",
+		"exec sqlcode.DropCodeSchema @schemasuffix = N'old'",
+		"drop procedure sqlcode.[CreateCodeSchema]",
+		"drop table sqlcode.[Version]",
+		"drop schema sqlcode",
+		"alter role db_owner drop member [certuser/sqlcode2]",
+		"drop user [certuser/sqlcode2]",
+		"drop certificate [cert/sqlcode2]",
+		"alter role [sqlcode-deploy-role] drop member [sqlcode-deploy-sandbox-user]",
+		"drop user [sqlcode-deploy-sandbox-user]",
+		"drop role [sqlcode-deploy-role]",
+	}
+	// The check does not stop a dry run
+	statements, err = Uninstall(ctx, dbc, UninstallOptions{DryRun: true})
+	require.True(t, errors.As(err, &inUse))
+	assert.Equal(t, expected, statements)
+
+	statements, err = Uninstall(ctx, dbc, UninstallOptions{DryRun: true, Force: true})
+	require.NoError(t, err)
+	assert.Equal(t, expected, statements)
+	assert.Equal(t, 0, fake.Count("drop certificate [cert/sqlcode2]"))
+
+	// Without VIEW SERVER STATE, when code was last used is unknown, and
+	// the schemas are considered in use
+	fake.FailOn("dm_exec_procedure_stats", mssql.Error{Number: 300, Message: "VIEW SERVER STATE permission was denied on object 'server', database 'master'."})
+	fake.Respond("cast(null as datetime)", []string{"name", "locked", "lastused"},
+		[]interface{}{"billing@old", false, nil},
+		[]interface{}{"code@old", false, nil},
+	)
+	_, err = Uninstall(ctx, dbc, UninstallOptions{})
+	require.True(t, errors.As(err, &inUse))
+	assert.Equal(t, []string{"billing@old", "code@old"}, []string{inUse.Schemas[0].Name, inUse.Schemas[1].Name})
+	assert.Contains(t, err.Error(), "- [billing@old] may be in use; reading when it was last used needs VIEW SERVER STATE")
+
+	// unless the check is turned off
+	statements, err = Uninstall(ctx, dbc, UninstallOptions{DryRun: true, RecentlyUsed: -1})
+	require.NoError(t, err)
+	assert.Equal(t, expected, statements)
+
+	statements, err = Uninstall(ctx, dbc, UninstallOptions{Force: true})
+	require.NoError(t, err)
+	assert.Equal(t, expected, statements)
+	assert.Equal(t, 1, fake.Count("drop certificate [cert/sqlcode2]"))
+	assert.Empty(t, fake.Schemas())
+}
@@ -0,0 +1,327 @@
+package sqlcode
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vippsas/sqlcode/sqlparser"
+)
+
+// GoReference is a reference to SQL code, such as `[code].MyProc`, in a Go
+// string literal passed to Patch; see ScanGoReferences
+type GoReference struct {
+	Pos  token.Position
+	Name string // e.g. `[code].MyProc` or `[billing].[Foo]`
+}
+
+var goReferenceRegexp = regexp.MustCompile(`\[[A-Za-z0-9_@-]+\]\s*\.\s*(?:\[[^\]]+\]|[A-Za-z_@#][A-Za-z0-9_@#$]*)`)
+
+// ScanGoReferences finds the references to SQL code in string literals in
+// the arguments of calls to methods named Patch (e.g. Deployable.Patch and
+// Version.Patch) in the Go files under dir. Literals may be concatenated with
+// +, and constants and variables initialized this way are followed: those at
+// package level also across the files of a package, and those declared in
+// the function making the call unless they are assigned again. Directories
+// named vendor or testdata, or starting with `.` or `_`, are skipped.
+func ScanGoReferences(dir string) (result []GoReference, err error) {
+	fset := token.NewFileSet()
+	var dirs []string
+	files := make(map[string][]*ast.File)
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			if path != dir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		}
+		if !strings.HasSuffix(name, ".go") {
+			return nil
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		file, err := parser.ParseFile(fset, path, src, parser.SkipObjectResolution)
+		if err != nil {
+			return err
+		}
+		files[filepath.Dir(path)] = append(files[filepath.Dir(path)], file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		// a directory may have both package x and x_test
+		packages := make(map[string]*goPackage)
+		for _, file := range files[dir] {
+			pkg, ok := packages[file.Name.Name]
+			if !ok {
+				pkg = &goPackage{values: make(map[string]ast.Expr), resolving: make(map[string]bool)}
+				packages[file.Name.Name] = pkg
+			}
+			pkg.addValues(file)
+		}
+		for _, file := range files[dir] {
+			result = append(result, packages[file.Name.Name].references(fset, file)...)
+		}
+	}
+	return result, nil
+}
+
+// goPackage has the package-level constants and variables of a Go package,
+// to find the strings passed to Patch by name
+type goPackage struct {
+	values    map[string]ast.Expr
+	locals    map[string]ast.Expr // of the function being scanned; see funcValues
+	resolving map[string]bool
+}
+
+func (p *goPackage) addValues(file *ast.File) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || (gen.Tok != token.CONST && gen.Tok != token.VAR) {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.ValueSpec)
+			if len(spec.Values) != len(spec.Names) {
+				continue
+			}
+			for i, name := range spec.Names {
+				p.values[name.Name] = spec.Values[i]
+			}
+		}
+	}
+}
+
+// funcValues finds the constants and variables declared in a function body.
+// Names that are declared more than once (e.g. in different blocks) or
+// assigned again are left out, as their value is not known.
+func funcValues(body *ast.BlockStmt) map[string]ast.Expr {
+	values := make(map[string]ast.Expr)
+	ambiguous := make(map[string]bool)
+	add := func(name string, value ast.Expr) {
+		if _, ok := values[name]; ok || value == nil {
+			ambiguous[name] = true
+		}
+		values[name] = value
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GenDecl:
+			if n.Tok != token.CONST && n.Tok != token.VAR {
+				return true
+			}
+			for _, spec := range n.Specs {
+				spec := spec.(*ast.ValueSpec)
+				for i, name := range spec.Names {
+					var value ast.Expr
+					if len(spec.Values) == len(spec.Names) {
+						value = spec.Values[i]
+					}
+					add(name.Name, value)
+				}
+			}
+		case *ast.AssignStmt:
+			for i, lhs := range n.Lhs {
+				ident, ok := lhs.(*ast.Ident)
+				if !ok {
+					continue
+				}
+				if n.Tok == token.DEFINE && len(n.Lhs) == len(n.Rhs) {
+					add(ident.Name, n.Rhs[i])
+				} else {
+					ambiguous[ident.Name] = true
+				}
+			}
+		case *ast.UnaryExpr:
+			// &q may be used to change q
+			if ident, ok := n.X.(*ast.Ident); ok && n.Op == token.AND {
+				ambiguous[ident.Name] = true
+			}
+		}
+		return true
+	})
+	for name := range ambiguous {
+		values[name] = nil
+	}
+	return values
+}
+
+// references finds the references in the arguments of calls to Patch in file
+func (p *goPackage) references(fset *token.FileSet, file *ast.File) (result []GoReference) {
+	for _, decl := range file.Decls {
+		p.locals = nil
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil {
+			p.locals = funcValues(fn.Body)
+			// parameters shadow the package-level names, with unknown values
+			for _, fields := range []*ast.FieldList{fn.Recv, fn.Type.Params} {
+				if fields == nil {
+					continue
+				}
+				for _, field := range fields.List {
+					for _, name := range field.Names {
+						p.locals[name.Name] = nil
+					}
+				}
+			}
+		}
+		result = append(result, p.declReferences(fset, decl)...)
+	}
+	p.locals = nil
+	return result
+}
+
+func (p *goPackage) declReferences(fset *token.FileSet, decl ast.Decl) (result []GoReference) {
+	ast.Inspect(decl, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		if sel, ok := call.Fun.(*ast.SelectorExpr); !ok || sel.Sel.Name != "Patch" {
+			return true
+		}
+		for _, arg := range call.Args {
+			ast.Inspect(arg, func(n ast.Node) bool {
+				if _, ok := n.(*ast.SelectorExpr); ok {
+					// x.Name does not refer to the constants of this package
+					return false
+				}
+				expr, ok := n.(ast.Expr)
+				if !ok {
+					return true
+				}
+				value, ok := p.constantString(expr)
+				if !ok {
+					return true
+				}
+				for _, m := range goReferenceRegexp.FindAllString(value, -1) {
+					result = append(result, GoReference{Pos: fset.Position(expr.Pos()), Name: m})
+				}
+				return false
+			})
+		}
+		return true
+	})
+	return result
+}
+
+// constantString evaluates string literals, also concatenated with + and
+// through constants and variables
+func (p *goPackage) constantString(expr ast.Expr) (string, bool) {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		if expr.Kind != token.STRING {
+			return "", false
+		}
+		value, err := strconv.Unquote(expr.Value)
+		return value, err == nil
+	case *ast.Ident:
+		value, ok := p.locals[expr.Name]
+		if !ok {
+			value, ok = p.values[expr.Name]
+		}
+		if !ok || value == nil || p.resolving[expr.Name] {
+			return "", false
+		}
+		p.resolving[expr.Name] = true
+		defer delete(p.resolving, expr.Name)
+		return p.constantString(value)
+	case *ast.ParenExpr:
+		return p.constantString(expr.X)
+	case *ast.BinaryExpr:
+		if expr.Op != token.ADD {
+			return "", false
+		}
+		x, ok := p.constantString(expr.X)
+		if !ok {
+			return "", false
+		}
+		y, ok := p.constantString(expr.Y)
+		return x + y, ok
+	}
+	return "", false
+}
+
+// IsEntrypoint is true for create statements with `entrypoint: true` in
+// their YAML docstring; see Unused
+func IsEntrypoint(c sqlparser.Create) bool {
+	var doc struct {
+		Entrypoint bool `yaml:"entrypoint"`
+	}
+	return c.ParseYamlInDocstring(&doc) == nil && doc.Entrypoint
+}
+
+// Unused lists the procedures, functions and types of the receiver that
+// can not be reached (through sqlparser.Create.DependsOn) from the roots,
+// which are the given names (e.g. from ScanGoReferences), the entrypoints
+// (e.g. listed in a config file) and the create statements marked as entry
+// points (see IsEntrypoint). Test procedures (see Options.ExcludeTests) are
+// not roots, so code only used by tests is unused; they are not listed
+// themselves. Names in [code] (or another namespace of the receiver) that
+// are not found are returned as unknown; e.g. references to code that has
+// been removed. Other names in roots, such as in [dbo] or imports, are
+// ignored, while all entrypoints that are not found are unknown.
+func (d Deployable) Unused(roots, entrypoints []string) (unused []sqlparser.Create, unknown []string) {
+	g := sqlparser.NewGraph(d.CodeBase.Creates)
+	var start []*sqlparser.Node
+	for _, n := range g.Nodes {
+		if IsEntrypoint(n.Create) {
+			start = append(start, n)
+		}
+	}
+	seen := make(map[string]bool)
+	names := append(append([]string(nil), roots...), entrypoints...)
+	for i, name := range names {
+		n, ok := g.Node(strings.Join(strings.Fields(name), ""))
+		if !ok {
+			if !seen[name] && (i >= len(roots) || d.isOwnNamespace(name)) {
+				unknown = append(unknown, name)
+			}
+			seen[name] = true
+			continue
+		}
+		start = append(start, n)
+	}
+
+	reachable := g.Reachable(start, false)
+	for _, n := range g.Nodes {
+		if !reachable[n.Name()] && !n.IsTestProcedure() {
+			unused = append(unused, n.Create)
+		}
+	}
+	sort.SliceStable(unused, func(i, j int) bool {
+		a, b := unused[i].QuotedName.Pos, unused[j].QuotedName.Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return unused, unknown
+}
+
+// isOwnNamespace is true for names like `[code].Foo` in one of the
+// namespaces of the receiver
+func (d Deployable) isOwnNamespace(name string) bool {
+	for _, ns := range d.CodeBase.Namespaces() {
+		if strings.HasPrefix(strings.ToLower(name), "["+strings.ToLower(ns)+"]") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package sqlcode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnused(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "svc"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "vendor"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "svc", "svc.go"), []byte(`package svc
+
+func run() {
+	db.QueryRowContext(ctx, SQL.Patch("select [code].GetName(@id)"), 1)
+	db.ExecContext(ctx, version.Patch(`+"`"+`exec [code].[Save] @x = 1;
+		select * from [dbo].Orders`+"`"+`))
+	db.ExecContext(ctx, SQL.Patch("exec [code]."+"Removed"))
+	fmt.Println("[code].NotPatched")
+}
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "svc", "queries.go"), []byte(`package svc
+
+const (
+	codeSchema = "[code]"
+	listQuery  = "select * from " + codeSchema + ".ListOrders()"
+)
+
+var cleanupQuery = "exec [code].Cleanup"
+
+func list() {
+	db.QueryContext(ctx, SQL.Patch(listQuery))
+	db.ExecContext(ctx, SQL.Patch(cleanupQuery + "; exec [code].Other"))
+	db.ExecContext(ctx, SQL.Patch(other.Query))
+}
+
+func local(listQuery string) {
+	const q = "exec [code].Local"
+	suffix := "; exec [code].Local2"
+	db.ExecContext(ctx, SQL.Patch(q+suffix))
+	changed := "exec [code].Before"
+	changed = "exec [code].After"
+	db.ExecContext(ctx, SQL.Patch(changed))
+	db.QueryContext(ctx, SQL.Patch(listQuery))
+}
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "svc", "svc_test.go"), []byte(`package svc_test
+
+func TestList() {
+	SQL.Patch(listQuery)
+}
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vendor", "v.go"), []byte(`package v
+var _ = SQL.Patch("[code].Vendored")
+`), 0644))
+
+	refs, err := ScanGoReferences(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, ref := range refs {
+		names = append(names, ref.Name)
+	}
+	assert.Equal(t, []string{"[code].ListOrders", "[code].Cleanup", "[code].Other",
+		"[code].Local", "[code].Local2", "[code].GetName", "[code].[Save]", "[dbo].Orders", "[code].Removed"}, names)
+	assert.Equal(t, 11, refs[0].Pos.Line)
+	assert.Equal(t, 4, refs[5].Pos.Line)
+
+	d, err := Include(Options{}, fstest.MapFS{
+		"a.sql": &fstest.MapFile{Data: []byte(`create function [code].GetName(@id int) returns int as begin return [code].Helper(@id) end
+go
+create function [code].Helper(@id int) returns int as begin return @id end
+go
+create procedure [code].[Save] (@x int) as select 1
+go
+--! entrypoint: true
+create procedure [code].Job as exec [code].JobHelper
+go
+create procedure [code].JobHelper as select 1
+go
+create procedure [code].Dead as exec [code].DeadHelper
+go
+create procedure [code].DeadHelper as select 1
+go
+create function [code].OnlyTested() returns int as begin return 1 end
+`)},
+		"a_test.sql": &fstest.MapFile{Data: []byte(`--sqlcode:test
+create procedure [code].OnlyTestedWorks as begin
+    declare @x int = [code].OnlyTested()
+    exec sqlcode.AssertEquals 1, @x
+end
+`)},
+	})
+	require.NoError(t, err)
+
+	unused, unknown := d.Unused(append(names, "[code].Vendored", "[CODE].[save]"), []string{"Job", "Jbo", "[code].Jbo"})
+	var unusedNames []string
+	for _, c := range unused {
+		unusedNames = append(unusedNames, c.QualifiedName())
+	}
+	// code only used by tests is unused; the tests are not listed
+	assert.Equal(t, []string{"[Dead]", "[DeadHelper]", "[OnlyTested]"}, unusedNames)
+	// misspelled entry points are reported also without a namespace
+	assert.Equal(t, []string{"[code].ListOrders", "[code].Cleanup", "[code].Other", "[code].Local", "[code].Local2",
+		"[code].Removed", "[code].Vendored", "Jbo", "[code].Jbo"}, unknown)
+}
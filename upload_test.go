@@ -0,0 +1,313 @@
+package sqlcode
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlcodetest"
+)
+
+func fakeDeployable(t *testing.T) Deployable {
+	return fakeDeployableWithOptions(t, Options{})
+}
+
+func fakeDeployableWithOptions(t *testing.T, opts Options) Deployable {
+	fs := fstest.MapFS{
+		"test.sql": &fstest.MapFile{Data: []byte(`create procedure [code].Foo as select 1
+go
+create procedure [code].Bar as exec [code].Foo
+`)},
+	}
+	d, err := Include(opts, fs)
+	require.NoError(t, err)
+	return d
+}
+
+func TestEnsureUploaded(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	d := fakeDeployable(t)
+
+	require.NoError(t, d.EnsureUploaded(ctx, dbc))
+	assert.Equal(t, []string{"code@" + d.SchemaSuffix}, fake.Schemas())
+	assert.Equal(t, 1, fake.Count("sqlcode.CreateCodeSchema"))
+	assert.Equal(t, 2, fake.Count("create procedure [code@"+d.SchemaSuffix+"]"))
+	assert.Equal(t, "", fake.LockMode("sqlcode.EnsureUploaded/"+d.SchemaSuffix))
+
+	// Cached; no statements are executed a second time
+	n := len(fake.Statements())
+	require.NoError(t, d.EnsureUploaded(ctx, dbc))
+	assert.Equal(t, n, len(fake.Statements()))
+	assert.True(t, d.IsUploadedFromCache(dbc))
+
+	// Another process (i.e. without the cache) finds the schema and does not upload again
+	other := fakeDeployable(t)
+	require.NoError(t, other.EnsureUploaded(ctx, dbc))
+	assert.Equal(t, 1, fake.Count("sqlcode.CreateCodeSchema"))
+	assert.True(t, other.IsUploadedFromCache(dbc))
+}
+
+func TestEnsureUploadedSingleConnection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	dbc.SetMaxOpenConns(1)
+	d := fakeDeployableWithOptions(t, Options{WarmupAfterUpload: true})
+
+	// The upload happens on the connection holding the lock; taking
+	// another one from the pool would wait forever
+	require.NoError(t, d.EnsureUploaded(ctx, dbc))
+	assert.Equal(t, []string{"code@" + d.SchemaSuffix}, fake.Schemas())
+	assert.Equal(t, "", fake.LockMode("sqlcode.EnsureUploaded/"+d.SchemaSuffix))
+}
+
+func TestUploadErrors(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	d := fakeDeployable(t)
+
+	// An error in a batch is reported with the position in the source, and
+	// the transaction is rolled back
+	fake.FailOnce("].Bar", mssql.Error{Number: 207, Message: "Invalid column name 'x'.", LineNo: 1,
+		All: []mssql.Error{{Number: 207, Message: "Invalid column name 'x'.", LineNo: 1}}})
+	err := d.EnsureUploaded(ctx, dbc)
+	var userErr SQLUserError
+	require.True(t, errors.As(err, &userErr))
+	assert.Equal(t, "[Bar]", userErr.Batch.QuotedName)
+	assert.Empty(t, fake.Schemas())
+	assert.False(t, d.IsUploadedFromCache(dbc))
+	assert.Equal(t, "", fake.LockMode("sqlcode.EnsureUploaded/"+d.SchemaSuffix))
+
+	// Infrastructure errors are passed on
+	fake.FailOnce("sp_getapplock", errors.New("connection reset"))
+	assert.EqualError(t, d.EnsureUploaded(ctx, dbc), "connection reset")
+	assert.Empty(t, fake.Schemas())
+
+	// ...and it works when the problems are gone
+	require.NoError(t, d.EnsureUploaded(ctx, dbc))
+	assert.Len(t, fake.Schemas(), 1)
+
+	// Upload refuses to overwrite
+	other := fakeDeployable(t)
+	err = other.Upload(ctx, dbc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "There is already an object named")
+}
+
+func TestEnsureUploadedConcurrently(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	// make the uploads slow enough for the callers to overlap
+	fake.Delay("create procedure", 20*time.Millisecond)
+
+	// Each caller is a separate Deployable, as if in its own process
+	const n = 10
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		d := fakeDeployable(t)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = d.EnsureUploaded(ctx, dbc)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Len(t, fake.Schemas(), 1)
+	assert.Equal(t, 1, fake.Count("sqlcode.CreateCodeSchema"))
+	assert.Equal(t, "", fake.LockMode("sqlcode.EnsureUploaded/"+fakeDeployable(t).SchemaSuffix))
+}
+
+func TestUploadCacheLeaderCanceled(t *testing.T) {
+	dbc := sqlcodetest.New().DB()
+	c := newUploadCache()
+
+	// The caller doing the upload gives up ...
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	leaderErr := make(chan error)
+	go func() {
+		leaderErr <- c.do(leaderCtx, dbc, func() error {
+			close(started)
+			<-leaderCtx.Done()
+			return leaderCtx.Err()
+		})
+	}()
+	<-started
+
+	// ... which should not fail the callers waiting for it; they upload instead
+	waiterErr := make(chan error)
+	waiterRan := false
+	go func() {
+		waiterErr <- c.do(context.Background(), dbc, func() error {
+			waiterRan = true
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-leaderErr, context.Canceled)
+	assert.NoError(t, <-waiterErr)
+	assert.True(t, waiterRan)
+
+	// Other errors are shared with the waiters
+	started = make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		leaderErr <- c.do(context.Background(), dbc, func() error {
+			close(started)
+			<-release
+			return errors.New("upload failed")
+		})
+	}()
+	<-started
+	go func() {
+		waiterErr <- c.do(context.Background(), dbc, func() error {
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	assert.EqualError(t, <-leaderErr, "upload failed")
+	assert.EqualError(t, <-waiterErr, "upload failed")
+}
+
+func TestUploadCache(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	fake.Delay("create procedure", 20*time.Millisecond)
+	d := fakeDeployable(t)
+
+	// Concurrent calls on the same Deployable (and copies of it) share a
+	// single upload
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		c := d
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, c.EnsureUploaded(ctx, dbc))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, fake.Count("sqlcode.CreateCodeSchema"))
+	assert.Equal(t, 2, fake.Count("sp_getapplock"))
+	assert.True(t, d.IsUploadedFromCache(dbc))
+
+	// Dropped by someone else; the cache has to be invalidated
+	other := fakeDeployable(t)
+	require.NoError(t, other.Drop(ctx, dbc))
+	assert.Empty(t, fake.Schemas())
+	require.NoError(t, d.EnsureUploaded(ctx, dbc))
+	assert.Empty(t, fake.Schemas())
+	d.Invalidate(dbc)
+	require.NoError(t, d.EnsureUploaded(ctx, dbc))
+	assert.Len(t, fake.Schemas(), 1)
+
+	// Drop invalidates by itself
+	require.NoError(t, d.Drop(ctx, dbc))
+	assert.False(t, d.IsUploadedFromCache(dbc))
+
+	// A Deployable not made by Include has no cache, but works
+	var zero Deployable
+	zero.CodeBase = d.CodeBase
+	zero.SchemaSuffix = "zero"
+	require.NoError(t, zero.EnsureUploaded(ctx, dbc))
+	assert.False(t, zero.IsUploadedFromCache(dbc))
+}
+
+func TestEnsureUploadedLockTimeout(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	d := fakeDeployableWithOptions(t, Options{LockTimeout: 10 * time.Millisecond})
+
+	// Someone else is holding the lock
+	holder, err := dbc.Conn(ctx)
+	require.NoError(t, err)
+	defer holder.Close()
+	var retcode int
+	require.NoError(t, holder.QueryRowContext(ctx, `
+declare @retcode int;
+exec @retcode = sp_getapplock @Resource = @resource, @LockMode = 'Exclusive', @LockOwner = 'Session';
+select @retcode;
+`, sql.Named("resource", "sqlcode.EnsureUploaded/"+d.SchemaSuffix)).Scan(&retcode))
+
+	err = d.EnsureUploaded(ctx, dbc)
+	assert.True(t, errors.Is(err, errLockTimeout))
+	assert.Empty(t, fake.Schemas())
+
+	// With retries, it waits for the lock to be released
+	d = fakeDeployableWithOptions(t, Options{LockTimeout: 10 * time.Millisecond, LockRetries: 100})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = holder.ExecContext(ctx, `sp_releaseapplock`, sql.Named("Resource", "sqlcode.EnsureUploaded/"+d.SchemaSuffix))
+	}()
+	require.NoError(t, d.EnsureUploaded(ctx, dbc))
+	assert.Len(t, fake.Schemas(), 1)
+}
+
+func TestDropAndUpload(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	d := fakeDeployable(t).WithSchemaSuffix("mytest")
+
+	require.NoError(t, d.DropAndUpload(ctx, dbc))
+	require.NoError(t, d.DropAndUpload(ctx, dbc))
+	assert.Equal(t, []string{"code@mytest"}, fake.Schemas())
+	assert.Equal(t, 1, fake.Count("sqlcode.DropCodeSchema"))
+
+	exists, err := Exists(ctx, dbc, "mytest")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	require.NoError(t, d.Drop(ctx, dbc))
+	assert.Empty(t, fake.Schemas())
+}
+
+func TestDropNamespacesDropsRoutinesFirst(t *testing.T) {
+	ctx := context.Background()
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	fake.CreateSchema("code@mytest")
+	fake.CreateSchema("billing@mytest")
+	fake.RespondFunc("from sys.objects", func(args map[string]interface{}) ([]string, [][]interface{}) {
+		return []string{"stmt"}, [][]interface{}{{"drop procedure [" + args["p1"].(string) + "].[Foo]"}}
+	})
+
+	require.NoError(t, DropNamespaces(ctx, dbc, "mytest", []string{"code", "billing", "missing"}))
+	assert.Empty(t, fake.Schemas())
+
+	var drops []string
+	for _, stmt := range fake.Statements() {
+		if strings.HasPrefix(stmt.Query, "drop procedure") || strings.HasPrefix(stmt.Query, "sqlcode.DropCodeSchema") {
+			drops = append(drops, stmt.Query)
+		}
+	}
+	assert.Equal(t, []string{
+		"drop procedure [code@mytest].[Foo]",
+		"drop procedure [billing@mytest].[Foo]",
+		"sqlcode.DropCodeSchema",
+		"sqlcode.DropCodeSchema",
+	}, drops)
+}
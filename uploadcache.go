@@ -0,0 +1,103 @@
+package sqlcode
+
+import (
+	"context"
+	"sync"
+)
+
+// uploadCache remembers which DBs a Deployable has been uploaded to. It is
+// shared by copies of the Deployable (but not by WithSchemaSuffix, which is
+// a different schema), and safe for concurrent use.
+//
+// The same physical DB can be in the cache multiple times under different
+// interfaces; that's fine; in general the same interface seems to be acquired.
+type uploadCache struct {
+	mu       sync.Mutex
+	uploaded map[DB]struct{}
+	inflight map[DB]*uploadCall
+}
+
+// uploadCall is an EnsureUploaded in progress, that concurrent callers wait for
+type uploadCall struct {
+	done     chan struct{}
+	err      error
+	canceled bool // err is due to the ctx of the caller running it being done
+}
+
+func newUploadCache() *uploadCache {
+	return &uploadCache{
+		uploaded: make(map[DB]struct{}),
+		inflight: make(map[DB]*uploadCall),
+	}
+}
+
+// The methods accept a nil receiver, for Deployables not made by Include
+
+func (c *uploadCache) has(dbc DB) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, found := c.uploaded[dbc]
+	return found
+}
+
+func (c *uploadCache) mark(dbc DB) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.uploaded[dbc] = struct{}{}
+}
+
+func (c *uploadCache) invalidate(dbc DB) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.uploaded, dbc)
+}
+
+// do runs f, unless a call for the same DB is already in progress; then it
+// waits for that and returns its result instead. f runs with the ctx of the
+// caller that started it, so the call fails if that ctx is done; the callers
+// waiting for it then try again themselves rather than getting the error.
+// A caller whose own ctx is done stops waiting.
+func (c *uploadCache) do(ctx context.Context, dbc DB, f func() error) error {
+	if c == nil {
+		return f()
+	}
+	c.mu.Lock()
+	for {
+		call, ok := c.inflight[dbc]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !call.canceled || ctx.Err() != nil {
+			return call.err
+		}
+		c.mu.Lock()
+	}
+	call := &uploadCall{done: make(chan struct{})}
+	c.inflight[dbc] = call
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, dbc)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	call.err = f()
+	call.canceled = call.err != nil && ctx.Err() != nil
+	return call.err
+}
@@ -0,0 +1,189 @@
+package sqlcode
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vippsas/sqlcode/sqlparser"
+	"gopkg.in/yaml.v3"
+)
+
+// WarmupConfig is the `warmup` key in the YAML docstring of a procedure,
+// which makes Deployable.Warmup call it:
+//
+//	--! warmup:
+//	--!   args: {customerID: 1, kind: "test"}
+//	--!   describe: false
+//	create procedure [code].Foo (@customerID bigint, @kind varchar(10)) as ...
+//
+// `warmup: true` calls the procedure without arguments.
+type WarmupConfig struct {
+	// Args are passed to the procedure by name (with or without the @)
+	Args map[string]interface{} `yaml:"args"`
+
+	// Describe only runs sp_describe_first_result_set on the call, instead of
+	// executing the procedure in a transaction that is rolled back; for
+	// procedures with side effects outside the transaction. This compiles the
+	// first query returning a result set, not the whole procedure.
+	Describe bool `yaml:"describe"`
+}
+
+// WarmupFailure is a procedure that failed during Deployable.Warmup
+type WarmupFailure struct {
+	Pos        sqlparser.Pos
+	QuotedName string
+	Err        error // resolved by Deployable.ResolveError
+}
+
+// WarmupError lists the procedures that failed during Deployable.Warmup
+type WarmupError struct {
+	Failures []WarmupFailure
+}
+
+func (e WarmupError) Error() string {
+	var msg strings.Builder
+	msg.WriteString("sqlcode warmup failed:\n")
+	for _, f := range e.Failures {
+		msg.WriteString(fmt.Sprintf("\n%s:%d:%d: %s: %s", f.Pos.File, f.Pos.Line, f.Pos.Col, f.QuotedName, f.Err))
+	}
+	return msg.String()
+}
+
+// warmupOf parses the warmup configuration in the docstring of c; nil if
+// there is none
+func warmupOf(c sqlparser.Create) (*WarmupConfig, error) {
+	var doc struct {
+		Warmup yaml.Node `yaml:"warmup"`
+	}
+	if err := c.ParseYamlInDocstring(&doc); err != nil {
+		return nil, err
+	}
+	switch {
+	case doc.Warmup.IsZero():
+		return nil, nil
+	case doc.Warmup.Kind == yaml.ScalarNode:
+		var enabled bool
+		if err := doc.Warmup.Decode(&enabled); err != nil {
+			return nil, err
+		}
+		if !enabled {
+			return nil, nil
+		}
+		return &WarmupConfig{}, nil
+	}
+	var result WarmupConfig
+	if err := doc.Warmup.Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Warmup calls the procedures that have `warmup` in their YAML docstring (see
+// WarmupConfig) so that they are compiled and their plans cached before
+// they are needed; this also finds errors that SQL Server does not report
+// when the code is created, such as references to columns that do not exist
+// (deferred name resolution). The procedures are called as the connected user,
+// one at a time. A WarmupError is returned if any of them fail.
+//
+// See also Options.WarmupAfterUpload.
+func (d Deployable) Warmup(ctx context.Context, dbc DB) error {
+	return d.warmupAll(ctx, dbc)
+}
+
+// warmupQuerier is the part of DB needed by Warmup; it is also implemented
+// by *sql.Conn
+type warmupQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	BeginTx(ctx context.Context, txOptions *sql.TxOptions) (*sql.Tx, error)
+}
+
+func (d Deployable) warmupAll(ctx context.Context, dbc warmupQuerier) error {
+	var failures []WarmupFailure
+	for _, c := range d.CodeBase.Creates {
+		cfg, err := warmupOf(c)
+		if err == nil && cfg != nil && c.CreateType != "procedure" {
+			err = fmt.Errorf("warmup is only supported for procedures")
+		}
+		if err == nil && cfg != nil {
+			err = d.ResolveError(d.warmup(ctx, dbc, c, *cfg))
+		}
+		if err != nil {
+			failures = append(failures, WarmupFailure{Pos: c.QuotedName.Pos, QuotedName: c.QualifiedName(), Err: err})
+		}
+	}
+	if len(failures) > 0 {
+		return WarmupError{Failures: failures}
+	}
+	return nil
+}
+
+func (d Deployable) warmup(ctx context.Context, dbc warmupQuerier, c sqlparser.Create, cfg WarmupConfig) error {
+	var names []string
+	for name := range cfg.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	procName := quoteName(NamespaceSchemaName(namespaceOrCode(c.Namespace), d.SchemaSuffix)) + "." + c.QuotedName.Value
+	var call strings.Builder
+	call.WriteString("exec " + procName)
+	var args []interface{}
+	for i, name := range names {
+		name := strings.TrimPrefix(name, "@")
+		if i > 0 {
+			call.WriteString(",")
+		}
+		if cfg.Describe {
+			literal, err := sqlLiteral(cfg.Args[names[i]])
+			if err != nil {
+				return fmt.Errorf("argument %s: %w", name, err)
+			}
+			call.WriteString(fmt.Sprintf(" @%s = %s", name, literal))
+		} else {
+			call.WriteString(fmt.Sprintf(" @%s = @%s", name, name))
+			args = append(args, sql.Named(name, cfg.Args[names[i]]))
+		}
+	}
+
+	if cfg.Describe {
+		rows, err := dbc.QueryContext(ctx, `sp_describe_first_result_set`, sql.Named("tsql", call.String()))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		return rows.Err()
+	}
+
+	tx, err := dbc.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	_, err = tx.ExecContext(ctx, call.String(), args...)
+	return err
+}
+
+// sqlLiteral formats a value from YAML as an SQL literal
+func sqlLiteral(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case string:
+		return quoteString(v), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
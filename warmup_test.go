@@ -0,0 +1,67 @@
+package sqlcode
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vippsas/sqlcode/sqlcodetest"
+)
+
+func TestWarmup(t *testing.T) {
+	ctx := context.Background()
+	fs := fstest.MapFS{
+		"warmup.sql": &fstest.MapFile{Data: []byte(`--! warmup:
+--!   args: {x: 1, "@name": "it's"}
+create procedure [code].Foo (@x int, @name varchar(10)) as
+select x from dbo.DoesNotExist
+go
+--! warmup: {describe: true, args: {id: 3}}
+create procedure [code].Bar (@id int) as select @id
+go
+--! warmup: false
+create procedure [code].Baz as select 1
+go
+--! warmup: true
+create function [code].F() returns int as begin return 1 end
+`)},
+	}
+	d, err := Include(Options{WarmupAfterUpload: true}, fs)
+	require.NoError(t, err)
+
+	fake := sqlcodetest.New()
+	dbc := fake.DB()
+	fake.FailOn("].[Foo] @name", mssql.Error{Number: 208, Message: "Invalid object name 'dbo.DoesNotExist'.", ProcName: "code@" + d.SchemaSuffix + ".Foo", LineNo: 4})
+
+	err = d.EnsureUploaded(ctx, dbc)
+	assert.EqualError(t, err, `sqlcode warmup failed:
+
+warmup.sql:3:25: [Foo]: warmup.sql:4:1 ([Foo]): Invalid object name 'dbo.DoesNotExist'.
+warmup.sql:13:24: [F]: warmup is only supported for procedures`)
+	// the code is uploaded anyway
+	assert.Len(t, fake.Schemas(), 1)
+
+	var calls []string
+	for _, s := range fake.Statements() {
+		if s.Query == "sp_describe_first_result_set" {
+			calls = append(calls, s.Args["tsql"].(string))
+		}
+		if s.Err != nil {
+			calls = append(calls, s.Query)
+			assert.Equal(t, map[string]interface{}{"x": int64(1), "name": "it's"}, s.Args)
+		}
+	}
+	assert.Equal(t, []string{
+		"exec [code@" + d.SchemaSuffix + "].[Foo] @name = @name, @x = @x",
+		"exec [code@" + d.SchemaSuffix + "].[Bar] @id = 3",
+	}, calls)
+	assert.Equal(t, 0, fake.Count("exec [code@"+d.SchemaSuffix+"].[Baz]"))
+
+	// The error is only reported to the caller that uploaded
+	assert.True(t, d.IsUploadedFromCache(dbc))
+	assert.NoError(t, d.EnsureUploaded(ctx, dbc))
+	assert.Error(t, d.Warmup(ctx, dbc))
+}